@@ -15,9 +15,9 @@ resource "singularity_request" "my-server" {
 }
 
 resource "singularity_request" "lenfree-run" {
-  request_id   = "lenfree-test-runonce"
-  request_type = "RUN_ONCE"
-  instances    = 5
+  request_id             = "lenfree-test-runonce"
+  request_type           = "RUN_ONCE"
+  num_retries_on_failure = 2
 }
 
 resource "singularity_request" "lenfree-scheduled" {
@@ -52,7 +52,7 @@ resource "singularity_docker_deploy" "test-deploy" {
   args       = ["-xc", "sleep 10000"]
   request_id = "${singularity_request.lenfree-service.id}"
 
-  resources = {
+  resources {
     cpus      = 2
     memory_mb = 128
   }
@@ -84,7 +84,7 @@ resource "singularity_docker_deploy" "test-deploy-2" {
   args       = ["-xc", "env"]
   request_id = "${singularity_request.lenfree-demand.id}"
 
-  resources = {
+  resources {
     cpus      = 2
     memory_mb = 128
   }
@@ -1,6 +1,10 @@
 package main
 
 import (
+	// Time zones are validated against the tz database, which Windows
+	// hosts don't have.
+	_ "time/tzdata"
+
 	"github.com/hashicorp/terraform/plugin"
 	singularity "github.com/packetloop/terraform-provider-singularity/mesos_singularity"
 )
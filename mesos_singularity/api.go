@@ -0,0 +1,172 @@
+package mesos_singularity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-resty/resty"
+	singularity "github.com/lenfree/go-singularity"
+)
+
+// callAPI sends a request to Singularity and decodes a successful JSON
+// response into out. The call, including its retries, gives up once ctx is
+// done. requestID and deployID are only used to give errors context. A
+// non-2xx response is returned as an *apiError.
+func callAPI(ctx context.Context, client *singularity.Client, method, path string, body, out interface{}, requestID, deployID string) (*resty.Response, error) {
+	req := client.Rest.R().SetContext(ctx)
+	if body != nil {
+		req.SetHeader("Content-Type", "application/json").SetBody(body)
+	}
+	resp, err := req.Execute(method, path)
+	if err != nil {
+		return nil, fmt.Errorf("Singularity %s %s error: %v", method, path, err)
+	}
+	if err := newAPIError(resp, requestID, deployID); err != nil {
+		return resp, err
+	}
+	if out != nil && len(resp.Body()) > 0 {
+		if err := client.Rest.JSONUnmarshal(resp.Body(), out); err != nil {
+			return resp, fmt.Errorf("parse Singularity %s %s response error: %v", method, path, err)
+		}
+	}
+	return resp, nil
+}
+
+// getRequest fetches request id.
+func getRequest(ctx context.Context, client *singularity.Client, id string) (requestParent, error) {
+	var r requestParent
+	_, err := callAPI(ctx, client, resty.MethodGet, "/api/requests/request/"+id, nil, &r, id, "")
+	return r, err
+}
+
+// isGone reports whether a getRequest result means the request was deleted,
+// either because Singularity no longer knows it or it is in the DELETED state.
+func isGone(r requestParent, err error) bool {
+	if err != nil {
+		return isNotFound(err)
+	}
+	return strings.ToUpper(r.State) == "DELETED"
+}
+
+// listRequests fetches every request.
+func listRequests(ctx context.Context, client *singularity.Client) (singularity.Requests, error) {
+	var r singularity.Requests
+	_, err := callAPI(ctx, client, resty.MethodGet, "/api/requests", nil, &r, "", "")
+	return r, err
+}
+
+// saveRequest creates a request, or updates it in place when its ID exists.
+func saveRequest(ctx context.Context, client *singularity.Client, req singularityRequest) (requestParent, error) {
+	var r requestParent
+	_, err := callAPI(ctx, client, resty.MethodPost, "/api/requests", req, &r, req.ID, "")
+	return r, err
+}
+
+// scaleRequestBody is the body of a scale call. A DurationMillis of zero makes
+// the scale permanent.
+type scaleRequestBody struct {
+	Instances        int    `json:"instances"`
+	Incremental      bool   `json:"incremental,omitempty"`
+	Bounce           bool   `json:"bounce,omitempty"`
+	SkipHealthchecks bool   `json:"skipHealthchecks,omitempty"`
+	DurationMillis   int64  `json:"durationMillis,omitempty"`
+	ActionID         string `json:"actionId,omitempty"`
+	Message          string `json:"message,omitempty"`
+}
+
+// scaleRequest changes the number of instances of request id.
+func scaleRequest(ctx context.Context, client *singularity.Client, id string, req scaleRequestBody) (singularity.SingularityRequestParent, error) {
+	var r singularity.SingularityRequestParent
+	_, err := callAPI(ctx, client, resty.MethodPut, "/api/requests/request/"+id+"/scale", req, &r, id, "")
+	return r, err
+}
+
+// removeRequest deletes request id and its deploys.
+func removeRequest(ctx context.Context, client *singularity.Client, id string, req singularity.SingularityDeleteRequest) error {
+	_, err := callAPI(ctx, client, resty.MethodDelete, "/api/requests/request/"+id, req, nil, id, "")
+	return err
+}
+
+// listActiveTasks fetches the running tasks of request id.
+func listActiveTasks(ctx context.Context, client *singularity.Client, id string) ([]taskIDHistory, error) {
+	var r []taskIDHistory
+	_, err := callAPI(ctx, client, resty.MethodGet, "/api/history/request/"+id+"/tasks/active", nil, &r, id, "")
+	return r, err
+}
+
+// getDeployHistory fetches deploy deployID of request requestID, including
+// its result once it has finished.
+func getDeployHistory(ctx context.Context, client *singularity.Client, requestID, deployID string) (deployHistory, error) {
+	var r deployHistory
+	_, err := callAPI(ctx, client, resty.MethodGet, "/api/history/request/"+requestID+"/deploy/"+deployID,
+		nil, &r, requestID, deployID)
+	return r, err
+}
+
+// createDeploy starts a new deploy of an existing request.
+func createDeploy(ctx context.Context, client *singularity.Client, req deployRequest) (singularity.SingularityRequestParent, error) {
+	var r singularity.SingularityRequestParent
+	_, err := callAPI(ctx, client, resty.MethodPost, "/api/deploys", req, &r,
+		req.Deploy.RequestID, req.Deploy.ID)
+	return r, err
+}
+
+// cancelDeploy cancels deploy deployID of request requestID while it is
+// pending.
+func cancelDeploy(ctx context.Context, client *singularity.Client, requestID, deployID string) error {
+	_, err := callAPI(ctx, client, resty.MethodDelete, "/api/deploys/deploy/"+deployID+"/request/"+requestID,
+		nil, nil, requestID, deployID)
+	return err
+}
+
+// pauseRequestBody is the body of a pause call. A DurationMillis of zero
+// pauses the request until it is unpaused.
+type pauseRequestBody struct {
+	KillTasks      bool   `json:"killTasks"`
+	DurationMillis int64  `json:"durationMillis,omitempty"`
+	ActionID       string `json:"actionId,omitempty"`
+	Message        string `json:"message,omitempty"`
+}
+
+// unpauseRequestBody is the body of an unpause call.
+type unpauseRequestBody struct {
+	SkipHealthchecks bool   `json:"skipHealthchecks,omitempty"`
+	ActionID         string `json:"actionId,omitempty"`
+	Message          string `json:"message,omitempty"`
+}
+
+// pauseRequest pauses request id.
+func pauseRequest(ctx context.Context, client *singularity.Client, id string, req pauseRequestBody) (singularity.SingularityRequestParent, error) {
+	var r singularity.SingularityRequestParent
+	_, err := callAPI(ctx, client, resty.MethodPost, "/api/requests/request/"+id+"/pause", req, &r, id, "")
+	return r, err
+}
+
+// unpauseRequest unpauses request id.
+func unpauseRequest(ctx context.Context, client *singularity.Client, id string, req unpauseRequestBody) (singularity.SingularityRequestParent, error) {
+	var r singularity.SingularityRequestParent
+	_, err := callAPI(ctx, client, resty.MethodPost, "/api/requests/request/"+id+"/unpause", req, &r, id, "")
+	return r, err
+}
+
+// getPriorityFreeze fetches the active priority freeze. Without one,
+// Singularity answers 404.
+func getPriorityFreeze(ctx context.Context, client *singularity.Client) (priorityFreezeParent, error) {
+	var r priorityFreezeParent
+	_, err := callAPI(ctx, client, resty.MethodGet, "/api/priority/freeze", nil, &r, "", "")
+	return r, err
+}
+
+// createPriorityFreeze freezes task priorities, replacing any active freeze.
+func createPriorityFreeze(ctx context.Context, client *singularity.Client, req priorityFreeze) (priorityFreezeParent, error) {
+	var r priorityFreezeParent
+	_, err := callAPI(ctx, client, resty.MethodPost, "/api/priority/freeze", req, &r, "", "")
+	return r, err
+}
+
+// deletePriorityFreeze lifts the active priority freeze.
+func deletePriorityFreeze(ctx context.Context, client *singularity.Client) error {
+	_, err := callAPI(ctx, client, resty.MethodDelete, "/api/priority/freeze", nil, nil, "", "")
+	return err
+}
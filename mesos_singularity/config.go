@@ -1,6 +1,14 @@
 package mesos_singularity
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty"
 	"github.com/lenfree/go-singularity"
 )
 
@@ -8,6 +16,12 @@ import (
 // It holds the connection information such as API endpoint to interface with.
 type Conn struct {
 	sclient *singularity.Client
+	stopCtx context.Context
+
+	// deleted holds the IDs of requests deleted during this run, which
+	// Singularity may still be cleaning up when they are created again.
+	mu      sync.Mutex
+	deleted map[string]bool
 }
 
 // Config holds the provider configuration, and delivers a populated
@@ -16,21 +30,136 @@ type Config struct {
 	Host  string
 	Port  int
 	Retry int
+
+	// RetryMinWait and RetryMaxWait bound the exponential backoff between
+	// retries.
+	RetryMinWait time.Duration
+	RetryMaxWait time.Duration
+
+	// Endpoint is a full API URL, including any path prefix Singularity
+	// is served under. When set, Host, Port and Scheme are ignored.
+	// Endpoints lists several of them to fail over between.
+	Endpoint  string
+	Endpoints []string
+
+	// Token is sent as a bearer token, Username and Password as HTTP
+	// basic auth. Only one of the two schemes can be used at a time.
+	Token    string
+	Username string
+	Password string
+	Headers  map[string]string
+
+	// Scheme overrides the port based guess of http or https. CAFile and
+	// CAPEM add a CA bundle, ClientCert and ClientKey enable mutual TLS.
+	Scheme             string
+	CAFile             string
+	CAPEM              string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
 }
 
 // Client returns a new client for accessing Singularity Rest API.
-// We don't do any authorisation as of the moment. Hence, this block
-// is simple.
+// Credentials, extra headers and TLS settings are set on the underlying
+// resty client, so every call made through it uses them.
 func (c *Config) Client() (*Conn, error) {
+	if err := c.validateAuth(); err != nil {
+		return nil, err
+	}
+	if c.RetryMinWait > c.RetryMaxWait {
+		return nil, fmt.Errorf("retry_min_wait (%s) must not be greater than retry_max_wait (%s)",
+			c.RetryMinWait, c.RetryMaxWait)
+	}
+	urls, err := c.endpointURLs()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	failover, err := newFailoverTransport(transport, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retries are done by our transport rather than resty, which retries
+	// regardless of status code or whether the call is safe to repeat.
 	cf := singularity.NewConfig().
 		SetHost(c.Host).
 		SetPort(c.Port).
-		SetRetry(c.Retry).
 		Build()
 
 	client := singularity.NewClient(cf)
+	client.Rest.SetTransport(&retryTransport{
+		next:    failover,
+		retries: c.Retry,
+		minWait: c.RetryMinWait,
+		maxWait: c.RetryMaxWait,
+	}).
+		SetHostURL(urls[0]).
+		SetRedirectPolicy(redirectPolicy(failover.hosts(), c.Headers))
+
+	if len(c.Headers) > 0 {
+		client.Rest.SetHeaders(c.Headers)
+	}
+	if c.Token != "" {
+		client.Rest.SetAuthToken(c.Token)
+	}
+	if c.Username != "" {
+		client.Rest.SetBasicAuth(c.Username, c.Password)
+	}
+	client.Rest.OnAfterResponse(traceResponse(c.secrets()))
 
 	return &Conn{
 		sclient: client,
 	}, nil
 }
+
+func (c *Config) validateAuth() error {
+	if c.Token != "" && (c.Username != "" || c.Password != "") {
+		return fmt.Errorf("token cannot be used together with username and password")
+	}
+	if (c.Username == "") != (c.Password == "") {
+		return fmt.Errorf("username and password must be set together")
+	}
+	return nil
+}
+
+// secrets returns every configured credential value, including header
+// values since those commonly carry API keys.
+func (c *Config) secrets() []string {
+	var s []string
+	for _, v := range []string{c.Token, c.Password} {
+		if v != "" {
+			s = append(s, v)
+		}
+	}
+	for _, v := range c.Headers {
+		if v != "" {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+// traceResponse logs every Singularity response at TRACE level. Only the
+// status and body are logged, never the request headers, and any configured
+// credential is masked in case the API echoes it back.
+func traceResponse(secrets []string) func(*resty.Client, *resty.Response) error {
+	return func(_ *resty.Client, r *resty.Response) error {
+		log.Printf("[TRACE] HTTP Response %s %s: %s %s",
+			r.Request.Method,
+			r.Request.URL,
+			r.Status(),
+			redact(string(r.Body()), secrets))
+		return nil
+	}
+}
+
+func redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, "[REDACTED]", -1)
+	}
+	return s
+}
@@ -0,0 +1,202 @@
+package mesos_singularity
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func testServerConfig(t *testing.T, ts *httptest.Server) Config {
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return Config{
+		Host: u.Hostname(),
+		Port: port,
+	}
+}
+
+// testServerConn starts a Singularity stub serving handler and connects to
+// it. The caller closes the server.
+func testServerConn(t *testing.T, handler http.HandlerFunc) (*Conn, *httptest.Server) {
+	ts := httptest.NewServer(handler)
+	config := Config{Endpoint: ts.URL}
+	conn, err := config.Client()
+	if err != nil {
+		ts.Close()
+		t.Fatalf("Client(): %v", err)
+	}
+	return conn, ts
+}
+
+func TestConfigClientAuth(t *testing.T) {
+	var data = []struct {
+		config Config
+		header string
+		expect string
+	}{
+		{
+			Config{Token: "s3cr3t"},
+			"Authorization",
+			"Bearer s3cr3t",
+		},
+		{
+			Config{Username: "admin", Password: "s3cr3t"},
+			"Authorization",
+			"Basic YWRtaW46czNjcjN0",
+		},
+		{
+			Config{Headers: map[string]string{"X-Api-Key": "s3cr3t"}},
+			"X-Api-Key",
+			"s3cr3t",
+		},
+	}
+
+	for _, tt := range data {
+		var got string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Get(tt.header)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		}))
+
+		config := testServerConfig(t, ts)
+		config.Token = tt.config.Token
+		config.Username = tt.config.Username
+		config.Password = tt.config.Password
+		config.Headers = tt.config.Headers
+
+		conn, err := config.Client()
+		if err != nil {
+			t.Fatalf("Client(): %v", err)
+		}
+		if _, err := conn.sclient.GetRequestByID("foo"); err != nil {
+			t.Fatalf("GetRequestByID(): %v", err)
+		}
+		if got != tt.expect {
+			t.Errorf("header %s: expected %q, got %q", tt.header, tt.expect, got)
+		}
+		ts.Close()
+	}
+}
+
+func TestConfigValidateAuth(t *testing.T) {
+	var data = []struct {
+		config    Config
+		expectErr bool
+	}{
+		{Config{}, false},
+		{Config{Token: "a"}, false},
+		{Config{Username: "a", Password: "b"}, false},
+		{Config{Token: "a", Username: "b", Password: "c"}, true},
+		{Config{Username: "a"}, true},
+		{Config{Password: "b"}, true},
+	}
+
+	for _, tt := range data {
+		err := tt.config.validateAuth()
+		if (err != nil) != tt.expectErr {
+			t.Errorf("validateAuth(%+v): expected error %v, got %v", tt.config, tt.expectErr, err)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	config := Config{
+		Token:   "t0k3n",
+		Headers: map[string]string{"X-Api-Key": "k3y"},
+	}
+	actual := redact(`{"token":"t0k3n","key":"k3y"}`, config.secrets())
+	expect := `{"token":"[REDACTED]","key":"[REDACTED]"}`
+	if actual != expect {
+		t.Errorf("redact: expected %s, got %s", expect, actual)
+	}
+}
+
+func TestConfigHostURL(t *testing.T) {
+	var data = []struct {
+		config Config
+		expect string
+	}{
+		{Config{Host: "localhost"}, "http://localhost"},
+		{Config{Host: "localhost", Port: 80}, "http://localhost"},
+		{Config{Host: "localhost", Port: 443}, "https://localhost"},
+		{Config{Host: "localhost", Port: 7099}, "http://localhost:7099"},
+		{Config{Host: "localhost", Port: 8443, Scheme: "https"}, "https://localhost:8443"},
+		{Config{Host: "localhost", Port: 8443, CAFile: "ca.pem"}, "https://localhost:8443"},
+		{Config{Host: "localhost", Port: 443, Scheme: "http"}, "http://localhost:443"},
+		{Config{Endpoint: "https://mesos.example.com/singularity/api"}, "https://mesos.example.com/singularity"},
+		{Config{Endpoint: "https://mesos.example.com:8443/singularity/api/"}, "https://mesos.example.com:8443/singularity"},
+		{Config{Endpoint: "http://localhost:7099/singularity"}, "http://localhost:7099/singularity"},
+		{Config{Endpoint: "http://localhost:7099", Host: "ignored"}, "http://localhost:7099"},
+	}
+
+	for _, tt := range data {
+		actual, err := tt.config.hostURL()
+		if err != nil {
+			t.Errorf("hostURL(%+v): %v", tt.config, err)
+		}
+		if actual != tt.expect {
+			t.Errorf("hostURL(%+v): expected %s, got %s", tt.config, tt.expect, actual)
+		}
+	}
+}
+
+func TestConfigClientTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	config := testServerConfig(t, ts)
+	config.CAPEM = string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	}))
+
+	conn, err := config.Client()
+	if err != nil {
+		t.Fatalf("Client(): %v", err)
+	}
+	r, err := conn.sclient.GetRequestByID("foo")
+	if err != nil {
+		t.Fatalf("GetRequestByID(): %v", err)
+	}
+	if r.RestyResponse.StatusCode() != 200 {
+		t.Errorf("expected status 200, got %d", r.RestyResponse.StatusCode())
+	}
+
+	config.CAPEM = "not a certificate"
+	if _, err := config.Client(); err == nil {
+		t.Errorf("expected an error for an invalid CA bundle")
+	}
+}
+
+func TestConfigClientEndpointPrefix(t *testing.T) {
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	config := Config{Endpoint: ts.URL + "/singularity/api"}
+	conn, err := config.Client()
+	if err != nil {
+		t.Fatalf("Client(): %v", err)
+	}
+	if _, err := conn.sclient.GetRequestByID("foo"); err != nil {
+		t.Fatalf("GetRequestByID(): %v", err)
+	}
+	expect := "/singularity/api/requests/request/foo"
+	if path != expect {
+		t.Errorf("expected path %s, got %s", expect, path)
+	}
+}
@@ -1,7 +1,40 @@
 package mesos_singularity
 
-import singularity "github.com/lenfree/go-singularity"
+import (
+	"context"
+	"strings"
+
+	singularity "github.com/lenfree/go-singularity"
+)
 
 func clientConn(m interface{}) *singularity.Client {
 	return m.(*Conn).sclient
 }
+
+// stopContext returns a context that is cancelled when Terraform stops the
+// provider, e.g. on Ctrl-C.
+func stopContext(m interface{}) context.Context {
+	if ctx := m.(*Conn).stopCtx; ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// recordDeletion remembers that request id was deleted during this run.
+func recordDeletion(m interface{}, id string) {
+	c := m.(*Conn)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deleted == nil {
+		c.deleted = make(map[string]bool)
+	}
+	c.deleted[strings.ToLower(id)] = true
+}
+
+// deletedInRun reports whether request id was deleted during this run.
+func deletedInRun(m interface{}, id string) bool {
+	c := m.(*Conn)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deleted[strings.ToLower(id)]
+}
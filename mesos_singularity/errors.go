@@ -0,0 +1,84 @@
+package mesos_singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-resty/resty"
+)
+
+// maxErrorMessage caps how much of a non-JSON response body ends up in an
+// error, e.g. an HTML error page from a proxy.
+const maxErrorMessage = 512
+
+// apiError is returned when Singularity answers a call with a non-2xx status.
+type apiError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+	RequestID  string
+	DeployID   string
+}
+
+func (e *apiError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Singularity %s %s", e.Method, e.Path)
+	switch {
+	case e.RequestID != "" && e.DeployID != "":
+		fmt.Fprintf(&b, " for request %q, deploy %q", e.RequestID, e.DeployID)
+	case e.RequestID != "":
+		fmt.Fprintf(&b, " for request %q", e.RequestID)
+	}
+	fmt.Fprintf(&b, " failed with status %d", e.StatusCode)
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	return b.String()
+}
+
+// newAPIError returns an *apiError describing resp, or nil when resp is a
+// success.
+func newAPIError(resp *resty.Response, requestID, deployID string) error {
+	if resp.StatusCode() >= 200 && resp.StatusCode() <= 299 {
+		return nil
+	}
+	e := &apiError{
+		StatusCode: resp.StatusCode(),
+		Message:    errorMessage(resp.Body()),
+		RequestID:  requestID,
+		DeployID:   deployID,
+	}
+	if req := resp.Request; req != nil {
+		e.Method = req.Method
+		e.Path = req.URL
+		if req.RawRequest != nil {
+			e.Path = req.RawRequest.URL.Path
+		}
+	}
+	return e
+}
+
+// errorMessage extracts the error message from a Singularity response body.
+// Singularity usually answers with {"message": "..."}; anything else is
+// returned trimmed.
+func errorMessage(body []byte) string {
+	var v struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &v); err == nil && v.Message != "" {
+		return v.Message
+	}
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > maxErrorMessage {
+		msg = msg[:maxErrorMessage] + "..."
+	}
+	return msg
+}
+
+// isNotFound reports whether err is a 404 from Singularity.
+func isNotFound(err error) bool {
+	e, ok := err.(*apiError)
+	return ok && e.StatusCode == 404
+}
@@ -0,0 +1,73 @@
+package mesos_singularity
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestErrorMessage(t *testing.T) {
+	var data = []struct {
+		body   string
+		expect string
+	}{
+		{`{"message": "Request foo is paused"}`, "Request foo is paused"},
+		{"  Deploy object is invalid\n", "Deploy object is invalid"},
+		{`{"status": 400}`, `{"status": 400}`},
+		{strings.Repeat("x", maxErrorMessage+1), strings.Repeat("x", maxErrorMessage) + "..."},
+	}
+
+	for _, tt := range data {
+		actual := errorMessage([]byte(tt.body))
+		if actual != tt.expect {
+			t.Errorf("errorMessage(%q): expected %q, got %q", tt.body, tt.expect, actual)
+		}
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	e := &apiError{
+		Method:     "POST",
+		Path:       "/api/deploys",
+		StatusCode: 400,
+		Message:    "Deploy object is invalid",
+		RequestID:  "foo",
+		DeployID:   "bar",
+	}
+	expect := `Singularity POST /api/deploys for request "foo", deploy "bar" failed with status 400: Deploy object is invalid`
+	if e.Error() != expect {
+		t.Errorf("Error(): expected %s, got %s", expect, e.Error())
+	}
+}
+
+func TestCallAPIError(t *testing.T) {
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/requests/request/missing" {
+			http.Error(w, "Couldn't find request missing", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Invalid schedule"}`))
+	})
+	defer ts.Close()
+
+	_, err := getRequest(context.Background(), conn.sclient, "missing")
+	if !isNotFound(err) {
+		t.Errorf("getRequest: expected a not found error, got %v", err)
+	}
+
+	_, err = getRequest(context.Background(), conn.sclient, "foo")
+	e, ok := err.(*apiError)
+	if !ok {
+		t.Fatalf("getRequest: expected an *apiError, got %T: %v", err, err)
+	}
+	if e.Method != "GET" || e.Path != "/api/requests/request/foo" || e.StatusCode != 400 ||
+		e.Message != "Invalid schedule" || e.RequestID != "foo" {
+		t.Errorf("getRequest: unexpected error %+v", e)
+	}
+	if isNotFound(err) {
+		t.Errorf("isNotFound: expected false for a 400")
+	}
+}
@@ -55,7 +55,7 @@ resource "singularity_docker_deploy" "phewphew" {
     }
   }
 
-  resources = {
+  resources {
     cpus      = 2
     memory_mb = 128
   }
@@ -0,0 +1,181 @@
+package mesos_singularity
+
+import (
+	singularity "github.com/lenfree/go-singularity"
+)
+
+// singularityRequest is the request object sent to and read from Singularity.
+// singularity.SingularityRequest gets several JSON names wrong and sends
+// zero values Singularity does not expect, so requests are built from this
+// model instead. Optional values the scheduler has its own default for are
+// pointers, so false or zero can still be sent.
+// https://github.com/HubSpot/Singularity/blob/master/Docs/reference/api.md#model-SingularityRequest
+type singularityRequest struct {
+	ID                                              string            `json:"id"`
+	RequestType                                     string            `json:"requestType"`
+	Instances                                       *int64            `json:"instances,omitempty"`
+	NumRetriesOnFailure                             int64             `json:"numRetriesOnFailure,omitempty"`
+	Schedule                                        string            `json:"schedule,omitempty"`
+	QuartzSchedule                                  string            `json:"quartzSchedule,omitempty"`
+	ScheduleType                                    string            `json:"scheduleType,omitempty"`
+	ScheduleTimeZone                                string            `json:"scheduleTimeZone,omitempty"`
+	ScheduledExpectedRuntimeMillis                  int64             `json:"scheduledExpectedRuntimeMillis,omitempty"`
+	TaskExecutionTimeLimitMillis                    int64             `json:"taskExecutionTimeLimitMillis,omitempty"`
+	SlavePlacement                                  string            `json:"slavePlacement,omitempty"`
+	RequiredSlaveAttributes                         map[string]string `json:"requiredSlaveAttributes,omitempty"`
+	AllowedSlaveAttributes                          map[string]string `json:"allowedSlaveAttributes,omitempty"`
+	RackAffinity                                    []string          `json:"rackAffinity,omitempty"`
+	RackSensitive                                   bool              `json:"rackSensitive,omitempty"`
+	MaxTasksPerOffer                                int               `json:"maxTasksPerOffer,omitempty"`
+	HideEvenNumberAcrossRacksHint                   bool              `json:"hideEvenNumberAcrossRacksHint,omitempty"`
+	AllowBounceToSameHost                           bool              `json:"allowBounceToSameHost,omitempty"`
+	Owners                                          []string          `json:"owners,omitempty"`
+	RequiredRole                                    string            `json:"requiredRole,omitempty"`
+	Group                                           string            `json:"group,omitempty"`
+	ReadOnlyGroups                                  []string          `json:"readOnlyGroups,omitempty"`
+	ReadWriteGroups                                 []string          `json:"readWriteGroups,omitempty"`
+	TaskLogErrorRegex                               string            `json:"taskLogErrorRegex,omitempty"`
+	TaskLogErrorRegexCaseSensitive                  *bool             `json:"taskLogErrorRegexCaseSensitive,omitempty"`
+	KillOldNonLongRunningTasksAfterMillis           int64             `json:"killOldNonLongRunningTasksAfterMillis,omitempty"`
+	WaitAtLeastMillisAfterTaskFinishesForReschedule int64             `json:"waitAtLeastMillisAfterTaskFinishesForReschedule,omitempty"`
+	TaskPriorityLevel                               *float64          `json:"taskPriorityLevel,omitempty"`
+}
+
+// instances returns the request's instance count, or zero when Singularity
+// did not report one.
+func (r singularityRequest) instances() int64 {
+	if r.Instances == nil {
+		return 0
+	}
+	return *r.Instances
+}
+
+// requestParent is a request as returned by Singularity. The request itself
+// is decoded into singularityRequest, which shadows the embedded
+// singularity.Request field of the same name.
+type requestParent struct {
+	singularity.Request
+	SingularityRequest singularityRequest                    `json:"request"`
+	ActiveDeploy       singularityDeploy                     `json:"activeDeploy"`
+	PendingDeploy      *singularityDeploy                    `json:"pendingDeploy"`
+	PendingDeployState *pendingDeploy                        `json:"pendingDeployState"`
+	ExpiringScale      *singularity.SingularityExpiringScale `json:"expiringScale"`
+	ExpiringPause      *expiringPause                        `json:"expiringPause"`
+}
+
+// expiringPause is a pause that ends on its own. singularity.
+// SingularityExpiringPause lacks its duration.
+// https://github.com/HubSpot/Singularity/blob/master/Docs/reference/api.md#model-SingularityExpiringPause
+type expiringPause struct {
+	StartMillis    int64 `json:"startMillis"`
+	DurationMillis int64 `json:"durationMillis"`
+}
+
+// singularityDeploy is a deploy as sent to and read from Singularity, with
+// the fields singularity.SingularityDeploy lacks.
+// https://github.com/HubSpot/Singularity/blob/master/Docs/reference/api.md#model-SingularityDeploy
+type singularityDeploy struct {
+	singularity.SingularityDeploy
+	Healthcheck *healthcheckOptions `json:"healthcheck,omitempty"`
+}
+
+// healthcheckOptions is a deploy's healthcheck. singularity.HealthcheckOptions
+// sends every field, and Singularity takes its zero values literally, e.g. a
+// response timeout of 0 seconds.
+// https://github.com/HubSpot/Singularity/blob/master/Docs/reference/api.md#model-HealthcheckOptions
+type healthcheckOptions struct {
+	URI                    string `json:"uri"`
+	Protocol               string `json:"protocol,omitempty"`
+	PortIndex              int    `json:"portIndex,omitempty"`
+	PortNumber             int64  `json:"portNumber,omitempty"`
+	StartupDelaySeconds    int    `json:"startupDelaySeconds,omitempty"`
+	StartupTimeoutSeconds  int    `json:"startupTimeoutSeconds,omitempty"`
+	StartupIntervalSeconds int    `json:"startupIntervalSeconds,omitempty"`
+	IntervalSeconds        int    `json:"intervalSeconds,omitempty"`
+	ResponseTimeoutSeconds int    `json:"responseTimeoutSeconds,omitempty"`
+	MaxRetries             int    `json:"maxRetries,omitempty"`
+	FailureStatusCodes     []int  `json:"failureStatusCodes,omitempty"`
+}
+
+// deployRequest is the body of a new deploy. singularity.SingularityDeployRequest
+// sends its optional fields with the wrong names.
+// https://github.com/HubSpot/Singularity/blob/master/Docs/reference/api.md#model-SingularityDeployRequest
+type deployRequest struct {
+	Deploy                    singularityDeploy `json:"deploy"`
+	UnpauseOnSuccessfulDeploy bool              `json:"unpauseOnSuccessfulDeploy,omitempty"`
+	Message                   string            `json:"message,omitempty"`
+}
+
+// priorityFreeze stops tasks below MinimumPriorityLevel from launching, and
+// with KillTasks kills those already running.
+// https://github.com/HubSpot/Singularity/blob/master/Docs/reference/api.md#model-SingularityPriorityFreeze
+type priorityFreeze struct {
+	MinimumPriorityLevel float64 `json:"minimumPriorityLevel"`
+	KillTasks            bool    `json:"killTasks"`
+	Message              string  `json:"message,omitempty"`
+	ActionID             string  `json:"actionId,omitempty"`
+}
+
+// priorityFreezeParent is the active priority freeze as returned by
+// Singularity.
+type priorityFreezeParent struct {
+	PriorityFreeze priorityFreeze `json:"priorityFreeze"`
+	Timestamp      int64          `json:"timestamp"`
+	User           string         `json:"user,omitempty"`
+}
+
+// taskID identifies a Singularity task.
+type taskID struct {
+	ID        string `json:"id"`
+	RequestID string `json:"requestId"`
+	DeployID  string `json:"deployId"`
+}
+
+// taskIDHistory is a task as listed in a request's task history.
+type taskIDHistory struct {
+	TaskID        taskID `json:"taskId"`
+	LastTaskState string `json:"lastTaskState,omitempty"`
+}
+
+// pendingDeploy is the progress of a request's pending deploy.
+// https://github.com/HubSpot/Singularity/blob/master/Docs/reference/api.md#model-SingularityPendingDeploy
+type pendingDeploy struct {
+	CurrentDeployState string         `json:"currentDeployState"`
+	DeployMarker       deployMarker   `json:"deployMarker"`
+	DeployProgress     deployProgress `json:"deployProgress"`
+}
+
+// deployMarker identifies a deploy.
+type deployMarker struct {
+	RequestID string `json:"requestId"`
+	DeployID  string `json:"deployId"`
+}
+
+// deployProgress is how far a pending deploy has got.
+type deployProgress struct {
+	CurrentActiveInstances int      `json:"currentActiveInstances"`
+	TargetActiveInstances  int      `json:"targetActiveInstances"`
+	FailedDeployTasks      []taskID `json:"failedDeployTasks"`
+}
+
+// deployHistory is a past or current deploy. DeployResult is nil until the
+// deploy has finished.
+// https://github.com/HubSpot/Singularity/blob/master/Docs/reference/api.md#model-SingularityDeployHistory
+type deployHistory struct {
+	DeployMarker deployMarker  `json:"deployMarker"`
+	DeployResult *deployResult `json:"deployResult"`
+}
+
+// deployResult is the outcome of a deploy.
+type deployResult struct {
+	DeployState    string          `json:"deployState"`
+	Message        string          `json:"message"`
+	DeployFailures []deployFailure `json:"deployFailures"`
+}
+
+// deployFailure is one reason a deploy failed, usually for a single task.
+type deployFailure struct {
+	Reason  string  `json:"reason"`
+	TaskID  *taskID `json:"taskId"`
+	Message string  `json:"message"`
+}
@@ -1,19 +1,45 @@
 package mesos_singularity
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 )
 
 // Provider returns a terraform.ResourceProvider.
 func Provider() terraform.ResourceProvider {
-	return &schema.Provider{
+	p := &schema.Provider{
 		Schema: map[string]*schema.Schema{
+			"endpoint": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				DefaultFunc:   schema.EnvDefaultFunc("SINGULARITY_ENDPOINT", nil),
+				ValidateFunc:  validateEndpoint,
+				ConflictsWith: []string{"host", "port", "scheme"},
+				Description:   "The full Singularity API URL, e.g. https://mesos.example.com/singularity/api.",
+			},
+
+			"endpoints": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateEndpoint,
+				},
+				ConflictsWith: []string{"endpoint", "host", "port", "scheme"},
+				Description:   "Full Singularity API URLs of every scheduler instance, tried in order until one answers.",
+			},
+
 			"host": &schema.Schema{
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("HOST", nil),
-				Description: "The Singularity API endpoint to interface with.",
+				Description: "The Singularity API host to interface with. Required unless endpoint is set.",
 			},
 
 			"port": &schema.Schema{
@@ -26,31 +52,180 @@ func Provider() terraform.ResourceProvider {
 			"retry": &schema.Schema{
 				Type:        schema.TypeInt,
 				Optional:    true,
-				DefaultFunc: schema.EnvDefaultFunc("retry", 3),
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{"SINGULARITY_RETRY", "retry"}, 3),
 				Description: "Number of times to retry when Singularity makes http requests. Defaults to 3.",
 			},
+
+			"retry_min_wait": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("SINGULARITY_RETRY_MIN_WAIT", "1s"),
+				ValidateFunc: validatePositiveDuration,
+				Description:  "Minimum time to wait between retries, e.g. 500ms. Must be greater than zero. Defaults to 1s.",
+			},
+
+			"retry_max_wait": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("SINGULARITY_RETRY_MAX_WAIT", "30s"),
+				ValidateFunc: validateDuration,
+				Description:  "Maximum time to wait between retries. Retries back off exponentially up to this value. Defaults to 30s.",
+			},
+
+			"token": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("SINGULARITY_TOKEN", nil),
+				Description: "Bearer token sent with every Singularity API call.",
+			},
+
+			"username": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("SINGULARITY_USERNAME", nil),
+				Description: "Username for HTTP basic authentication.",
+			},
+
+			"password": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("SINGULARITY_PASSWORD", nil),
+				Description: "Password for HTTP basic authentication.",
+			},
+
+			"headers": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Sensitive:   true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Additional HTTP headers sent with every Singularity API call. Defaults to SINGULARITY_HEADERS, comma separated name=value pairs.",
+			},
+
+			"scheme": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("SINGULARITY_SCHEME", nil),
+				ValidateFunc: validateScheme,
+				Description:  "Either http or https. Defaults to https on port 443 or when TLS settings are given, http otherwise.",
+			},
+
+			"ca_file": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				DefaultFunc:   schema.EnvDefaultFunc("SINGULARITY_CA_FILE", nil),
+				ConflictsWith: []string{"ca_pem"},
+				Description:   "Path to a PEM encoded CA bundle used to verify the Singularity server certificate.",
+			},
+
+			"ca_pem": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"ca_file"},
+				Description:   "PEM encoded CA bundle used to verify the Singularity server certificate.",
+			},
+
+			"client_cert": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("SINGULARITY_CLIENT_CERT", nil),
+				Description: "PEM encoded client certificate, or a path to one, for mutual TLS.",
+			},
+
+			"client_key": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("SINGULARITY_CLIENT_KEY", nil),
+				Description: "PEM encoded client private key, or a path to one, for mutual TLS.",
+			},
+
+			"insecure_skip_verify": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("SINGULARITY_INSECURE_SKIP_VERIFY", false),
+				Description: "Skip verification of the Singularity server certificate.",
+			},
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"singularity_request":       resourceRequest(),
-			"singularity_docker_deploy": resourceDockerDeploy(),
+			"singularity_request":         resourceRequest(),
+			"singularity_docker_deploy":   resourceDockerDeploy(),
+			"singularity_priority_freeze": resourcePriorityFreeze(),
 		},
 
 		/* DataSources placeholder
 		DataSourcesMap: map[string]*schema.Resource{
 		},
 		*/
+	}
 
-		ConfigureFunc: providerConfigure,
+	p.ConfigureFunc = func(d *schema.ResourceData) (interface{}, error) {
+		return providerConfigure(d, p.StopContext())
 	}
+	return p
 }
 
-func providerConfigure(d *schema.ResourceData) (interface{}, error) {
+func providerConfigure(d *schema.ResourceData, stopCtx context.Context) (interface{}, error) {
+	// Both durations are checked by validateDuration.
+	retryMinWait, _ := time.ParseDuration(d.Get("retry_min_wait").(string))
+	retryMaxWait, _ := time.ParseDuration(d.Get("retry_max_wait").(string))
+
+	// Maps don't take a DefaultFunc, so the environment is read here.
+	headers := tagsToMap(d.Get("headers").(map[string]interface{}))
+	if v := os.Getenv("SINGULARITY_HEADERS"); len(headers) == 0 && v != "" {
+		var err error
+		if headers, err = parseHeaders(v); err != nil {
+			return nil, fmt.Errorf("SINGULARITY_HEADERS: %v", err)
+		}
+	}
+
 	config := Config{
 		Host:  d.Get("host").(string),
 		Port:  d.Get("port").(int),
 		Retry: d.Get("retry").(int),
+
+		RetryMinWait: retryMinWait,
+		RetryMaxWait: retryMaxWait,
+
+		Endpoint: d.Get("endpoint").(string),
+		Token:    d.Get("token").(string),
+		Username: d.Get("username").(string),
+		Password: d.Get("password").(string),
+		Headers:  headers,
+
+		Scheme:             d.Get("scheme").(string),
+		CAFile:             d.Get("ca_file").(string),
+		CAPEM:              d.Get("ca_pem").(string),
+		ClientCert:         d.Get("client_cert").(string),
+		ClientKey:          d.Get("client_key").(string),
+		InsecureSkipVerify: d.Get("insecure_skip_verify").(bool),
 	}
 
-	return config.Client()
+	for _, e := range d.Get("endpoints").([]interface{}) {
+		config.Endpoints = append(config.Endpoints, e.(string))
+	}
+
+	conn, err := config.Client()
+	if err != nil {
+		return nil, err
+	}
+	conn.stopCtx = stopCtx
+	return conn, nil
+}
+
+// parseHeaders parses comma separated name=value pairs, e.g.
+// "X-Api-Key=s3cr3t,X-Team=infra".
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" {
+			return nil, fmt.Errorf("expected name=value, got %q", pair)
+		}
+		headers[name] = strings.TrimSpace(kv[1])
+	}
+	return headers, nil
 }
@@ -2,9 +2,13 @@ package mesos_singularity
 
 import (
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
 
+	"github.com/hashicorp/terraform/config"
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 )
@@ -29,6 +33,57 @@ func TestProvider_impl(t *testing.T) {
 	var _ terraform.ResourceProvider = Provider()
 }
 
+func TestParseHeaders(t *testing.T) {
+	var data = []struct {
+		input  string
+		expect map[string]string
+		err    bool
+	}{
+		{"X-Api-Key=s3cr3t", map[string]string{"X-Api-Key": "s3cr3t"}, false},
+		{"X-Api-Key=a=b, X-Team = infra", map[string]string{"X-Api-Key": "a=b", "X-Team": "infra"}, false},
+		{"X-Empty=", map[string]string{"X-Empty": ""}, false},
+		{"X-Api-Key", nil, true},
+		{"=s3cr3t", nil, true},
+		{"X-Api-Key=s3cr3t,", nil, true},
+	}
+	for _, tt := range data {
+		headers, err := parseHeaders(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("parseHeaders(%q): expected error %v, got %v", tt.input, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(headers, tt.expect) {
+			t.Errorf("parseHeaders(%q): expected %v, got %v", tt.input, tt.expect, headers)
+		}
+	}
+}
+
+func TestProviderConfigureHeadersFromEnv(t *testing.T) {
+	defer os.Setenv("SINGULARITY_HEADERS", os.Getenv("SINGULARITY_HEADERS"))
+	os.Setenv("SINGULARITY_HEADERS", "X-Api-Key=k3y")
+
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Api-Key")
+	}))
+	defer ts.Close()
+
+	c, err := config.NewRawConfig(map[string]interface{}{"endpoint": ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Provider().(*schema.Provider)
+	if err := p.Configure(terraform.NewResourceConfig(c)); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if _, err := p.Meta().(*Conn).sclient.Rest.R().Get("/api/state"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != "k3y" {
+		t.Errorf("expected X-Api-Key k3y from SINGULARITY_HEADERS, got %q", got)
+	}
+}
+
 func testAccPreCheck(t *testing.T) {
 	if v := os.Getenv("HOST"); v == "" {
 		log.Println("[INFO] Test: Using 'localhost' as test host")
@@ -2,16 +2,19 @@ package mesos_singularity
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
-
-	"math/rand"
 	"time"
 
 	"github.com/cydev/zero"
-	petname "github.com/dustinkirkland/golang-petname"
 	"github.com/hashicorp/terraform/helper/customdiff"
 	"github.com/hashicorp/terraform/helper/hashcode"
 	"github.com/hashicorp/terraform/helper/schema"
@@ -19,36 +22,40 @@ import (
 )
 
 func resourceDockerDeploy() *schema.Resource {
-	return &schema.Resource{
+	r := &schema.Resource{
 		Create: resourceDockerDeployCreate,
 		Read:   resourceDockerDeployRead,
 		Exists: resourceDockerDeployExists,
 		Update: resourceDockerDeployUpdate,
 		Delete: resourceDockerDeployDelete,
 		CustomizeDiff: customdiff.Sequence(
-			customdiff.ComputedIf("deploy_id", func(d *schema.ResourceDiff, meta interface{}) bool {
-				change := d.HasChange("request_id") ||
-					d.HasChange("resources") ||
-					d.HasChange("args") ||
-					d.HasChange("command") ||
-					d.HasChange("envs") ||
-					d.HasChange("uri")
-				// TODO: Dealing with deep nested map is not fun at all.
-				// Make a deep nested compare on has change function to
-				// trigger this function when a param changes
-				// d.HasChange("container_info")
-				return change
-			}),
+			customizeHealthcheck,
+			customizeNumPorts,
+			customizeDeployID,
 		),
 		Importer: &schema.ResourceImporter{
 			State: resourceResourceDockerDeployImport,
 		},
 
+		SchemaVersion: 1,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(15 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(15 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+
 		Schema: map[string]*schema.Schema{
 			"deploy_id": &schema.Schema{
 				Type:     schema.TypeString,
 				Computed: true,
 			},
+			"deploy_id_prefix": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateDeployIDPrefix,
+			},
 			"request_id": &schema.Schema{
 				Type:     schema.TypeString,
 				Required: true,
@@ -62,18 +69,31 @@ func resourceDockerDeploy() *schema.Resource {
 				Optional: true,
 			},
 			"resources": &schema.Schema{
-				Type:     schema.TypeMap,
+				Type:     schema.TypeList,
 				Optional: true,
+				MaxItems: 1,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
+						"cpus": &schema.Schema{
+							Type:     schema.TypeFloat,
+							Optional: true,
+						},
 						"memory_mb": &schema.Schema{
 							Type:     schema.TypeFloat,
 							Optional: true,
 						},
-						"cpus": &schema.Schema{
+						"disk_mb": &schema.Schema{
 							Type:     schema.TypeFloat,
 							Optional: true,
 						},
+						// Without num_ports, enough ports are requested
+						// for the FROM_OFFER port mappings.
+						"num_ports": &schema.Schema{
+							Type:             schema.TypeInt,
+							Optional:         true,
+							ValidateFunc:     validatePositiveInt,
+							DiffSuppressFunc: suppressDerivedNumPortsDiff,
+						},
 					},
 				},
 			},
@@ -104,6 +124,30 @@ func resourceDockerDeploy() *schema.Resource {
 										Default:      "BRIDGE",
 										ValidateFunc: validateDockerNetwork,
 									},
+									"privileged": &schema.Schema{
+										Type:     schema.TypeBool,
+										Optional: true,
+										Default:  false,
+									},
+									// A list rather than a map, as docker run
+									// flags such as --cap-add can be repeated
+									// and their order may matter.
+									"parameter": &schema.Schema{
+										Type:     schema.TypeList,
+										Optional: true,
+										Elem: &schema.Resource{
+											Schema: map[string]*schema.Schema{
+												"key": &schema.Schema{
+													Type:     schema.TypeString,
+													Required: true,
+												},
+												"value": &schema.Schema{
+													Type:     schema.TypeString,
+													Required: true,
+												},
+											},
+										},
+									},
 									// We use typeSet because this parameter can be unordered list and must be unique.
 									"port_mapping": &schema.Schema{
 										Type:     schema.TypeSet,
@@ -169,6 +213,89 @@ func resourceDockerDeploy() *schema.Resource {
 				Optional: true,
 				Default:  true,
 			},
+			"healthcheck": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"uri": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"protocol": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "HTTP",
+							ValidateFunc: validateHealthcheckProtocol,
+						},
+						"port_index": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validatePositiveInt,
+						},
+						"port_number": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validatePositiveInt,
+						},
+						"startup_delay_seconds": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validatePositiveInt,
+						},
+						"startup_timeout_seconds": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validatePositiveInt,
+						},
+						"startup_interval_seconds": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validatePositiveInt,
+						},
+						"interval_seconds": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validatePositiveInt,
+						},
+						"response_timeout_seconds": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validatePositiveInt,
+						},
+						"max_retries": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validatePositiveInt,
+						},
+						"failure_status_codes": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem:     &schema.Schema{Type: schema.TypeInt},
+						},
+					},
+				},
+			},
+			// Without a setting, healthchecks gate the deploy when a
+			// healthcheck is configured. See customizeHealthcheck.
+			"skip_healthchecks_on_deploy": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Computed: true,
+			},
+			"wait_for_deploy": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			// Destroying a deploy used to delete its request. This restores
+			// that for configurations without a singularity_request.
+			"delete_request_on_destroy": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 			"envs":     envSchema(),
 			"metadata": envSchema(),
 			"uri": &schema.Schema{
@@ -198,6 +325,15 @@ func resourceDockerDeploy() *schema.Resource {
 			},
 		},
 	}
+
+	r.StateUpgraders = []schema.StateUpgrader{
+		{
+			Version: 0,
+			Type:    resourceDockerDeployV0(r.Schema).CoreConfigSchema().ImpliedType(),
+			Upgrade: resourceDockerDeployStateUpgradeV0,
+		},
+	}
+	return r
 }
 
 func envSchema() *schema.Schema {
@@ -211,22 +347,17 @@ func resourceDockerDeployExists(d *schema.ResourceData, m interface{}) (b bool,
 
 	// Exists - This is called to verify a resource still exists. It is called prior to Read,
 	// and lowers the burden of Read to be able to assume the resource exists.
-	client := clientConn(m)
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutRead))
+	defer cancel()
 	id := d.Get("request_id").(string)
-	r, err := client.GetRequestByID(id)
-	if err != nil {
-		return false, fmt.Errorf("%v", err)
+	r, err := getRequest(ctx, clientConn(m), id)
+	if isGone(r, err) {
+		return false, nil
 	}
-	if r.RestyResponse.StatusCode() == 400 {
-		return false, fmt.Errorf("Request 400 ID: %v, %v", id, string(r.RestyResponse.Body()))
-	}
-	if strings.ToLower(r.Body.State) == ("paused") {
-		return true, fmt.Errorf(
-			"Request ID: %v is in paused state, please unpause before continuing",
-			id,
-		)
+	if err != nil {
+		return false, err
 	}
-	if strings.ToLower(r.Body.State) == ("system_cooldown") {
+	if strings.ToLower(r.State) == ("system_cooldown") {
 		log.Printf("[INFO] Request ID: (%v) is in system cooldown state", id)
 		d.MarkNewResource()
 	}
@@ -272,22 +403,113 @@ func expandUris(configured []interface{}) ([]singularity.SingularityMesosArtifac
 	return uris, nil
 }
 
-func expandResources(d *schema.ResourceData, portMappings int64) (singularity.SingularityDeployResources, error) {
+func expandResources(configured []interface{}, portMappings []singularity.DockerPortMapping) singularity.SingularityDeployResources {
+	var resources singularity.SingularityDeployResources
+	if len(configured) > 0 && configured[0] != nil {
+		data := configured[0].(map[string]interface{})
+		resources.Cpus = data["cpus"].(float64)
+		resources.MemoryMb = data["memory_mb"].(float64)
+		resources.DiskMb = data["disk_mb"].(float64)
+		resources.NumPorts = int64(data["num_ports"].(int))
+	}
+	if resources.NumPorts == 0 {
+		resources.NumPorts = offerPortCount(portMappings)
+	}
+	return resources
+}
 
-	cpus, err := strconv.ParseFloat(d.Get("resources.cpus").(string), 64)
-	if err != nil {
-		return singularity.SingularityDeployResources{}, fmt.Errorf("Error converting cpus to float64: %v", err)
+// suppressDerivedNumPortsDiff hides the count Singularity reports when
+// num_ports is not set and the deploy got as many ports as its port mappings
+// use.
+func suppressDerivedNumPortsDiff(k, old, new string, d *schema.ResourceData) bool {
+	if new != "" && new != "0" {
+		return false
 	}
-	memoryMb, err := strconv.ParseFloat(d.Get("resources.memory_mb").(string), 64)
-	if err != nil {
-		return singularity.SingularityDeployResources{}, fmt.Errorf("Error converting memory_mb to float64: %v", err)
+	n := offerPortCount(expandContainerInfo(d).DockerInfo.PortMappings)
+	return n > 0 && old == strconv.FormatInt(n, 10)
+}
+
+// customizeHealthcheck plans skip_healthchecks_on_deploy when it is not set:
+// healthchecks are skipped on deploy unless a healthcheck is configured.
+// Setting it to true alongside a healthcheck is an error, as the healthcheck
+// would never gate a deploy. A value left unset on update is the one in
+// state, which is indistinguishable from setting that same value.
+func customizeHealthcheck(d *schema.ResourceDiff, m interface{}) error {
+	if !d.NewValueKnown("healthcheck") {
+		return nil
+	}
+	_, healthcheck := d.GetOk("healthcheck")
+	if !d.NewValueKnown("skip_healthchecks_on_deploy") {
+		return d.SetNew("skip_healthchecks_on_deploy", !healthcheck)
+	}
+	skip := d.Get("skip_healthchecks_on_deploy").(bool)
+	switch {
+	case d.Id() == "" || d.HasChange("skip_healthchecks_on_deploy"):
+		if healthcheck && skip {
+			return fmt.Errorf("healthcheck has no effect while skip_healthchecks_on_deploy is true; " +
+				"remove skip_healthchecks_on_deploy or set it to false for the healthcheck to gate deploys")
+		}
+	case healthcheck && skip:
+		// Skipped by the old default of true.
+		return d.SetNew("skip_healthchecks_on_deploy", false)
+	case !healthcheck && !skip && d.HasChange("healthcheck"):
+		return d.SetNew("skip_healthchecks_on_deploy", true)
 	}
+	return nil
+}
+
+// customizeNumPorts rejects a num_ports too small for the FROM_OFFER port
+// mappings, which Singularity would fail to deploy.
+func customizeNumPorts(d *schema.ResourceDiff, m interface{}) error {
+	if !d.NewValueKnown("resources") || !d.NewValueKnown("container_info") {
+		return nil
+	}
+	v, ok := d.GetOk("resources.0.num_ports")
+	if !ok {
+		return nil
+	}
+	numPorts := int64(v.(int))
+	portMappings := expandContainerInfo(d).DockerInfo.PortMappings
+	n := offerPortCount(portMappings)
+	if numPorts >= n {
+		return nil
+	}
+	var short []string
+	for _, p := range portMappings {
+		if p.HostPortType == "FROM_OFFER" && int64(p.HostPort) >= numPorts ||
+			p.ContainerPortType == "FROM_OFFER" && int64(p.ContainerPort) >= numPorts {
+			short = append(short, fmt.Sprintf("container_port %d to host_port %d", p.ContainerPort, p.HostPort))
+		}
+	}
+	sort.Strings(short)
+	return fmt.Errorf("num_ports is %d, but the FROM_OFFER port mappings need %d; "+
+		"raise num_ports or leave it unset for these port mappings: %s",
+		numPorts, n, strings.Join(short, "; "))
+}
+
+// offerPortCount returns how many ports the FROM_OFFER port mappings use.
+// Their port is an index into the ports taken from the Mesos offer, so this
+// is the highest index plus one.
+func offerPortCount(portMappings []singularity.DockerPortMapping) int64 {
+	var n int64
+	for _, p := range portMappings {
+		if p.HostPortType == "FROM_OFFER" && int64(p.HostPort) >= n {
+			n = int64(p.HostPort) + 1
+		}
+		if p.ContainerPortType == "FROM_OFFER" && int64(p.ContainerPort) >= n {
+			n = int64(p.ContainerPort) + 1
+		}
+	}
+	return n
+}
 
-	return singularity.SingularityDeployResources{
-		Cpus:     cpus,
-		MemoryMb: memoryMb,
-		NumPorts: 2,
-	}, nil
+func flattenResources(in singularity.SingularityDeployResources) []interface{} {
+	m := make(map[string]interface{})
+	m["cpus"] = in.Cpus
+	m["memory_mb"] = in.MemoryMb
+	m["disk_mb"] = in.DiskMb
+	m["num_ports"] = int(in.NumPorts)
+	return []interface{}{m}
 }
 
 func expandPortMappings(configured *schema.Set) []singularity.DockerPortMapping {
@@ -310,30 +532,48 @@ func expandPortMappings(configured *schema.Set) []singularity.DockerPortMapping
 	return portMappings
 }
 
+func expandDockerParameters(configured []interface{}) []singularity.SingularityDockerParameter {
+	var parameters []singularity.SingularityDockerParameter
+	for _, pRaw := range configured {
+		data := pRaw.(map[string]interface{})
+		parameters = append(parameters, singularity.SingularityDockerParameter{
+			Key:   data["key"].(string),
+			Value: data["value"].(string),
+		})
+	}
+	return parameters
+}
+
 func expandDockerInfo(d map[string]interface{}) singularity.DockerInfo {
 	a := d["docker_info"].([]interface{})
 	var portMappings []singularity.DockerPortMapping
+	var parameters []singularity.SingularityDockerParameter
 	var forcePullImage bool
+	var privileged bool
 	var network string
 	var image string
 	for _, i := range a {
 		if i, ok := i.(map[string]interface{}); ok {
 			forcePullImage = i["force_pull_image"].(bool)
+			privileged = i["privileged"].(bool)
 			network = i["network"].(string)
 			image = i["image"].(string)
 			pm := i["port_mapping"].(*schema.Set)
 			portMappings = expandPortMappings(pm)
+			parameters = expandDockerParameters(i["parameter"].([]interface{}))
 		}
 	}
 	return singularity.DockerInfo{
-		ForcePullImage: forcePullImage,
-		Network:        network,
-		Image:          image,
-		PortMappings:   portMappings,
+		ForcePullImage:              forcePullImage,
+		Privileged:                  privileged,
+		Network:                     network,
+		Image:                       image,
+		PortMappings:                portMappings,
+		SingularityDockerParameters: parameters,
 	}
 }
 
-func expandContainerInfo(d *schema.ResourceData) singularity.ContainerInfo {
+func expandContainerInfo(d resourceGetter) singularity.ContainerInfo {
 	a := d.Get("container_info").([]interface{})
 
 	var dockerInfo singularity.DockerInfo
@@ -350,7 +590,9 @@ func expandContainerInfo(d *schema.ResourceData) singularity.ContainerInfo {
 }
 
 func resourceDockerDeployCreate(d *schema.ResourceData, m interface{}) error {
-	return createDockerDeploy(d, m)
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutCreate))
+	defer cancel()
+	return createDockerDeploy(ctx, d, m)
 }
 
 func tagsToMap(tags map[string]interface{}) map[string]string {
@@ -373,7 +615,57 @@ func tagsFromMap(m map[string]string) []map[string]string {
 
 	return result
 }
-func buildDeployRequest(d *schema.ResourceData) singularity.DeployRequest {
+func expandHealthcheck(configured []interface{}) *healthcheckOptions {
+	if len(configured) == 0 || configured[0] == nil {
+		return nil
+	}
+	data := configured[0].(map[string]interface{})
+	var codes []int
+	for _, c := range data["failure_status_codes"].([]interface{}) {
+		codes = append(codes, c.(int))
+	}
+	return &healthcheckOptions{
+		URI:                    data["uri"].(string),
+		Protocol:               data["protocol"].(string),
+		PortIndex:              data["port_index"].(int),
+		PortNumber:             int64(data["port_number"].(int)),
+		StartupDelaySeconds:    data["startup_delay_seconds"].(int),
+		StartupTimeoutSeconds:  data["startup_timeout_seconds"].(int),
+		StartupIntervalSeconds: data["startup_interval_seconds"].(int),
+		IntervalSeconds:        data["interval_seconds"].(int),
+		ResponseTimeoutSeconds: data["response_timeout_seconds"].(int),
+		MaxRetries:             data["max_retries"].(int),
+		FailureStatusCodes:     codes,
+	}
+}
+
+func flattenHealthcheck(in *healthcheckOptions) []interface{} {
+	if in == nil {
+		return []interface{}{}
+	}
+	m := make(map[string]interface{})
+	m["uri"] = in.URI
+	m["protocol"] = in.Protocol
+	m["port_index"] = in.PortIndex
+	m["port_number"] = in.PortNumber
+	m["startup_delay_seconds"] = in.StartupDelaySeconds
+	m["startup_timeout_seconds"] = in.StartupTimeoutSeconds
+	m["startup_interval_seconds"] = in.StartupIntervalSeconds
+	m["interval_seconds"] = in.IntervalSeconds
+	m["response_timeout_seconds"] = in.ResponseTimeoutSeconds
+	m["max_retries"] = in.MaxRetries
+	m["failure_status_codes"] = in.FailureStatusCodes
+	return []interface{}{m}
+}
+
+// resourceGetter reads the configuration of a resource. Both
+// *schema.ResourceData and *schema.ResourceDiff implement it, so a deploy can
+// be built when applying and when planning.
+type resourceGetter interface {
+	Get(key string) interface{}
+}
+
+func buildDeployRequest(d resourceGetter) deployRequest {
 	requestID := strings.ToLower(d.Get("request_id").(string))
 	command := d.Get("command").(string)
 	arguments := d.Get("args").([]interface{})
@@ -385,7 +677,7 @@ func buildDeployRequest(d *schema.ResourceData) singularity.DeployRequest {
 
 	info := expandContainerInfo(d)
 
-	resources, _ := expandResources(d, int64(len(info.DockerInfo.PortMappings)))
+	resources := expandResources(d.Get("resources").([]interface{}), info.DockerInfo.PortMappings)
 
 	dep := singularity.NewDeploy("")
 	dep.SetURIs(uris)
@@ -406,57 +698,331 @@ func buildDeployRequest(d *schema.ResourceData) singularity.DeployRequest {
 	deploy := containerInfo.SetCommand(command).
 		SetRequestID(requestID).
 		SetResources(resources).
-		SetSkipHealthchecksOnDeploy(true).
+		SetSkipHealthchecksOnDeploy(d.Get("skip_healthchecks_on_deploy").(bool)).
 		Build()
 
 	resp := singularity.NewDeployRequest().
 		AttachDeploy(deploy).
 		Build()
 
-	return resp
+	return deployRequest{
+		Deploy: singularityDeploy{
+			SingularityDeploy: resp.SingularityDeploy,
+			Healthcheck:       expandHealthcheck(d.Get("healthcheck").([]interface{})),
+		},
+	}
 }
 
-func generateRandomPetName() string {
-	rand.Seed(time.Now().UnixNano())
-	return petname.Generate(2, "")
+// deploySpecFields are the arguments a deploy is built from. Changing any of
+// them creates a new deploy.
+var deploySpecFields = []string{
+	"request_id",
+	"deploy_id_prefix",
+	"container_info",
+	"resources",
+	"healthcheck",
+	"skip_healthchecks_on_deploy",
+	"args",
+	"command",
+	"envs",
+	"uri",
 }
 
-func createDockerDeploy(d *schema.ResourceData, m interface{}) error {
+const (
+	// maxDeployIDLength is Singularity's default limit on deploy IDs.
+	maxDeployIDLength = 50
+	// deployIDHashLength is how many hex digits of the spec hash are used.
+	deployIDHashLength = 16
+	// maxDeployAttempts is how often the same spec is deployed, each
+	// attempt after the first getting a "_<attempt>" suffix.
+	maxDeployAttempts       = 99
+	deployIDAttemptLength   = 3
+	maxDeployIDPrefixLength = maxDeployIDLength - deployIDHashLength - deployIDAttemptLength - 1
+)
 
-	client := clientConn(m)
-	// Workaround update ID with md5sum of config params
-	md5 := generateRandomPetName()
-	d.SetId(md5)
-	deployRequest := buildDeployRequest(d).SetID(md5)
+// deploySpec is the normalised form of a deploy that its ID is derived from.
+// Port mappings, volumes and URIs are sets in the configuration, so they are
+// sorted.
+type deploySpec struct {
+	RequestID                string                                   `json:"requestId"`
+	Command                  string                                   `json:"command"`
+	Arguments                []string                                 `json:"arguments"`
+	Env                      map[string]string                        `json:"env"`
+	URIs                     []singularity.SingularityMesosArtifact   `json:"uris"`
+	Cpus                     float64                                  `json:"cpus"`
+	MemoryMb                 float64                                  `json:"memoryMb"`
+	DiskMb                   float64                                  `json:"diskMb"`
+	NumPorts                 int64                                    `json:"numPorts"`
+	Image                    string                                   `json:"image"`
+	Network                  string                                   `json:"network"`
+	ForcePullImage           bool                                     `json:"forcePullImage"`
+	Privileged               bool                                     `json:"privileged"`
+	PortMappings             []singularity.DockerPortMapping          `json:"portMappings"`
+	DockerParameters         []singularity.SingularityDockerParameter `json:"dockerParameters"`
+	Volumes                  []singularity.SingularityVolume          `json:"volumes"`
+	Healthcheck              *healthcheckOptions                      `json:"healthcheck"`
+	SkipHealthchecksOnDeploy bool                                     `json:"skipHealthchecksOnDeploy"`
+}
 
-	log.Printf("Singularity deploy '%s' is being provisioned...", md5)
-	resp, err := deployRequest.Create(client)
+func newDeploySpec(deploy singularityDeploy) deploySpec {
+	docker := deploy.ContainerInfo.DockerInfo
+	spec := deploySpec{
+		RequestID:                deploy.RequestID,
+		Command:                  deploy.Command,
+		Arguments:                deploy.Arguments,
+		Env:                      deploy.Env,
+		Cpus:                     deploy.Cpus,
+		MemoryMb:                 deploy.MemoryMb,
+		DiskMb:                   deploy.DiskMb,
+		NumPorts:                 deploy.NumPorts,
+		Image:                    docker.Image,
+		Network:                  docker.Network,
+		ForcePullImage:           docker.ForcePullImage,
+		Privileged:               docker.Privileged,
+		DockerParameters:         docker.SingularityDockerParameters,
+		Healthcheck:              deploy.Healthcheck,
+		SkipHealthchecksOnDeploy: deploy.SkipHealthchecksOnDeploy,
+	}
+	spec.URIs = append(spec.URIs, deploy.Uris...)
+	sort.Slice(spec.URIs, func(i, j int) bool {
+		return fmt.Sprint(spec.URIs[i]) < fmt.Sprint(spec.URIs[j])
+	})
+	spec.PortMappings = append(spec.PortMappings, docker.PortMappings...)
+	sort.Slice(spec.PortMappings, func(i, j int) bool {
+		return fmt.Sprint(spec.PortMappings[i]) < fmt.Sprint(spec.PortMappings[j])
+	})
+	spec.Volumes = append(spec.Volumes, deploy.ContainerInfo.Volumes...)
+	sort.Slice(spec.Volumes, func(i, j int) bool {
+		return fmt.Sprint(spec.Volumes[i]) < fmt.Sprint(spec.Volumes[j])
+	})
+	return spec
+}
+
+// deployID returns a deploy ID derived from the deploy spec, so the same
+// configuration always gets the same ID.
+func deployID(prefix string, deploy singularityDeploy) (string, error) {
+	b, err := json.Marshal(newDeploySpec(deploy))
 	if err != nil {
-		return fmt.Errorf("Singularity create job deploy ID: %v, error: %+v", d.Get("ID"), err)
+		return "", fmt.Errorf("encode deploy of request %s: %v", deploy.RequestID, err)
+	}
+	sum := sha256.Sum256(b)
+	id := hex.EncodeToString(sum[:])[:deployIDHashLength]
+	if prefix != "" {
+		id = prefix + "_" + id
 	}
+	return id, nil
+}
 
-	return checkDeployResponse(d, m, resp, err)
+// nextDeployID returns the ID to deploy the spec with ID id as. Singularity
+// refuses an ID it has seen before, so a spec that was deployed and is no
+// longer active, e.g. because that deploy failed, is deployed again with an
+// attempt suffix. The active or pending deploy keeps its ID.
+func nextDeployID(ctx context.Context, client *singularity.Client, r requestParent, id string) (string, error) {
+	requestID := r.SingularityRequest.ID
+	for attempt := 1; attempt <= maxDeployAttempts; attempt++ {
+		next := id
+		if attempt > 1 {
+			next = fmt.Sprintf("%s_%d", id, attempt)
+		}
+		if r.ActiveDeploy.ID == next || r.PendingDeploy != nil && r.PendingDeploy.ID == next {
+			return next, nil
+		}
+		_, err := getDeployHistory(ctx, client, requestID, next)
+		if isNotFound(err) {
+			return next, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("deploy %s of request %s was already tried %d times, change the deploy or deploy_id_prefix to deploy it again",
+		id, requestID, maxDeployAttempts)
 }
 
-func checkDeployResponse(d *schema.ResourceData, m interface{}, r singularity.HTTPResponse, err error) error {
-	//log.Printf("[INFO] check Deploy Response HTTP Response %v", r.RestyResponse)
+// customizeDeployID plans the ID of the deploy a change creates, so the plan
+// shows it. It is only known once every argument is.
+func customizeDeployID(d *schema.ResourceDiff, m interface{}) error {
+	changed := d.Id() == ""
+	for _, key := range deploySpecFields {
+		if !d.NewValueKnown(key) {
+			return d.SetNewComputed("deploy_id")
+		}
+		changed = changed || d.HasChange(key)
+	}
+	if !changed {
+		return nil
+	}
+	deploy := buildDeployRequest(d).Deploy
+	id, err := deployID(d.Get("deploy_id_prefix").(string), deploy)
 	if err != nil {
-		return fmt.Errorf("Create Singularity Deploy response error: %v", err)
+		return err
+	}
+	// The lookup can take up to maxDeployAttempts calls, bounded as a whole.
+	ctx, cancel := operationContext(m, planTimeout)
+	defer cancel()
+	r, err := getRequest(ctx, clientConn(m), deploy.RequestID)
+	if err == nil {
+		id, err = nextDeployID(ctx, clientConn(m), r, id)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s looking up the deploys of request %s", planTimeout, deploy.RequestID)
+	}
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if id == d.Get("deploy_id").(string) {
+		return nil
+	}
+	return d.SetNew("deploy_id", id)
+}
+
+// createDockerDeploy creates a new deploy and, with wait_for_deploy, waits
+// until ctx is done for it to succeed. Nothing is deployed when the request
+// is already running or rolling out a deploy with the same spec.
+func createDockerDeploy(ctx context.Context, d *schema.ResourceData, m interface{}) error {
+
+	client := clientConn(m)
+	deployRequest := buildDeployRequest(d)
+	id, err := deployID(d.Get("deploy_id_prefix").(string), deployRequest.Deploy)
+	if err != nil {
+		return err
+	}
+
+	r, err := getRequest(ctx, client, deployRequest.Deploy.RequestID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if id, err = nextDeployID(ctx, client, r, id); err != nil {
+			return err
+		}
 	}
+	d.SetId(id)
+	deployRequest.Deploy.ID = id
 
-	if r.RestyResponse.StatusCode() < 200 && r.RestyResponse.StatusCode() > 299 {
-		return fmt.Errorf("Create Singularity Deploy response error: %v, %+v", r.RestyResponse.StatusCode(), err.Error())
+	switch {
+	case r.ActiveDeploy.ID == id:
+		log.Printf("[INFO] Singularity deploy '%s' is already active", id)
+		return readDockerDeploy(ctx, d, m)
+	case r.PendingDeploy != nil && r.PendingDeploy.ID == id:
+		log.Printf("[INFO] Singularity deploy '%s' is already pending", id)
+	default:
+		log.Printf("Singularity deploy '%s' is being provisioned...", id)
+		if _, err := createDeploy(ctx, client, deployRequest); err != nil {
+			return err
+		}
+	}
+	if !d.Get("wait_for_deploy").(bool) {
+		return readDockerDeploy(ctx, d, m)
+	}
+	return waitForDeploy(ctx, d, m)
+}
+
+// waitForDeploy waits until ctx is done for the deploy to finish and reads it
+// back. It fails unless the deploy succeeded.
+func waitForDeploy(ctx context.Context, d *schema.ResourceData, m interface{}) error {
+	requestID := strings.ToLower(d.Get("request_id").(string))
+	if err := waitForDeployResult(ctx, clientConn(m), requestID, d.Id()); err != nil {
+		return err
 	}
-	return resourceDockerDeployRead(d, m)
+	return readDockerDeploy(ctx, d, m)
 }
 
-func getRequestID(id string, client *singularity.Client) (singularity.HTTPResponse, error) {
-	r, err := client.GetRequestByID(id)
+// waitForDeployResult polls deploy deployID of request requestID until it has
+// a result, and returns an error describing the result unless it succeeded.
+func waitForDeployResult(ctx context.Context, client *singularity.Client, requestID, deployID string) error {
+	var result *deployResult
+	var progress deployProgress
+	what := fmt.Sprintf("deploy %s of request %s to finish", deployID, requestID)
+	err := waitFor(ctx, deployPollInterval, what, func() (bool, error) {
+		r, err := getRequest(ctx, client, requestID)
+		if err != nil {
+			return false, err
+		}
+		if p := r.PendingDeployState; p != nil && p.DeployMarker.DeployID == deployID {
+			progress = p.DeployProgress
+			log.Printf("[DEBUG] Deploy %s of request %s is %s with %d of %d instances active",
+				deployID, requestID, p.CurrentDeployState,
+				progress.CurrentActiveInstances, progress.TargetActiveInstances)
+			return false, nil
+		}
+		h, err := getDeployHistory(ctx, client, requestID, deployID)
+		if isNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		result = h.DeployResult
+		return result != nil, nil
+	})
 	if err != nil {
-		return singularity.HTTPResponse{}, fmt.Errorf("Get Singularity Request by ID: %v error: %v, %v", id, r.RestyResponse.StatusCode(), err)
+		return err
 	}
-	log.Printf("[INFO] GET RESPONSE ID: %v\n, %+v\n", id, r.Body)
-	return r, nil
+	return deployResultError(requestID, deployID, result, progress)
+}
+
+// deployResultError returns nil for a successful deploy, or an error with
+// Singularity's reasons and the failed task IDs. Tasks are also taken from
+// the deploy's last progress, as not every failure names its task.
+func deployResultError(requestID, deployID string, result *deployResult, progress deployProgress) error {
+	if result.DeployState == "SUCCEEDED" {
+		return nil
+	}
+
+	var reasons, tasks []string
+	seen := make(map[string]bool)
+	addTask := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			tasks = append(tasks, id)
+		}
+	}
+	if result.Message != "" {
+		reasons = append(reasons, result.Message)
+	}
+	for _, f := range result.DeployFailures {
+		reason := f.Reason
+		if f.TaskID != nil {
+			reason += " on task " + f.TaskID.ID
+			addTask(f.TaskID.ID)
+		}
+		if f.Message != "" {
+			reason += ": " + f.Message
+		}
+		reasons = append(reasons, reason)
+	}
+	for _, t := range progress.FailedDeployTasks {
+		addTask(t.ID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "deploy %s of request %s ended %s", deployID, requestID, result.DeployState)
+	if len(reasons) > 0 {
+		fmt.Fprintf(&b, ": %s", strings.Join(reasons, "; "))
+	}
+	if len(tasks) > 0 {
+		fmt.Fprintf(&b, " (failed tasks: %s)", strings.Join(tasks, ", "))
+	}
+	return errors.New(b.String())
+}
+
+// waitForPendingDeploy polls the request until it has no pending deploy and
+// returns its final state.
+func waitForPendingDeploy(ctx context.Context, client *singularity.Client, requestID, deployID string) (requestParent, error) {
+	var r requestParent
+	what := fmt.Sprintf("deploy %s of request %s to leave the pending state", deployID, requestID)
+	err := waitFor(ctx, deployPollInterval, what, func() (bool, error) {
+		var err error
+		r, err = getRequest(ctx, client, requestID)
+		if err != nil {
+			return false, err
+		}
+		log.Printf("[DEBUG] Pending deploy of request %s: %q", requestID,
+			r.RequestDeployState.PendingDeployState.DeployID)
+		return zero.IsZero(r.RequestDeployState.PendingDeployState.DeployID), nil
+	})
+	return r, err
 }
 
 // resourceRequestRead is called to resync the local state with the remote state.
@@ -464,23 +1030,41 @@ func getRequestID(id string, client *singularity.Client) (singularity.HTTPRespon
 // to look up the resource. Any remote data should be updated into the local data.
 // No changes to the remote resource are to be made.
 func resourceDockerDeployRead(d *schema.ResourceData, m interface{}) error {
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutRead))
+	defer cancel()
+	return readDockerDeploy(ctx, d, m)
+}
+
+// readDockerDeploy refreshes the deploy's state, giving up once ctx is done.
+func readDockerDeploy(ctx context.Context, d *schema.ResourceData, m interface{}) error {
 	client := clientConn(m)
 	//deploy_id := d.Get("deploy_id").(string)
 	//r, err := client.GetRequestByID(d.Get("request_id").(string))
 	//log.Printf("[TRACE] Deploy Read HTTP Response %v", r.Body)
 
-	// Expensive loop. Only use this during import because we don't have access to other attributes than
-	// GetID(). Otherwise, use getrequestsbyid.
-	_, b, err := client.GetRequests()
-	if err != nil {
+	id := d.Id()
+	requestID := d.Get("request_id").(string)
+	if requestID == "" {
+		// Expensive loop. Only use this during import because we don't have access to other attributes than
+		// GetID(). Otherwise, use getrequestsbyid.
+		b, err := listRequests(ctx, client)
+		if err != nil {
+			return err
+		}
+		requestID = b.GetRequestID(id).SingularityRequest.ID
+		if requestID == "" {
+			log.Printf("[WARN] No request has deploy %s, removing it from state", id)
+			d.SetId("")
+			return nil
+		}
+	}
+	r, err := getRequest(ctx, client, requestID)
+	if isGone(r, err) {
+		log.Printf("[WARN] Request %s of deploy %s no longer exists, removing it from state", requestID, id)
 		d.SetId("")
-		return err
+		return nil
 	}
-	id := d.Id()
-	c := b.GetRequestID(id)
-	r, err := getRequestID(c.SingularityRequest.ID, client)
 	if err != nil {
-		d.SetId("")
 		return err
 	}
 	log.Printf("[INFO] ***** %+v", r)
@@ -488,42 +1072,37 @@ func resourceDockerDeployRead(d *schema.ResourceData, m interface{}) error {
 	// When we create a service request, a deploy does not run immediately by default
 	// and deploy would be in pending state. We want to wait for pending task to be
 	// active and return result to user.
-	if r.Body.RequestDeployState.PendingDeployState.DeployID != "" {
-
-		// TODO: Fix this quick workaround retry block.
-		for i := 0; i <= 10; i++ {
-			r, err = getRequestID(c.SingularityRequest.ID, client)
+	// Without wait_for_deploy, a deploy still rolling out is read as is. A
+	// paused request does not roll out its pending deploy, so it is never
+	// waited for.
+	deploy := r.ActiveDeploy
+	if r.RequestDeployState.PendingDeployState.DeployID != "" {
+		paused := strings.ToUpper(r.State) == "PAUSED"
+		if (paused || !d.Get("wait_for_deploy").(bool)) && r.PendingDeploy != nil && r.PendingDeploy.ID == id {
+			deploy = *r.PendingDeploy
+		} else if paused {
+			log.Printf("[INFO] Request %s is paused, reading its active deploy", requestID)
+		} else {
+			r, err = waitForPendingDeploy(ctx, client, requestID, id)
 			if err != nil {
-				d.SetId("")
 				return err
 			}
-			log.Printf("[INFO] RETRY: %v, %+v", i, r)
-			if zero.IsZero(r.Body.RequestDeployState.PendingDeployState.DeployID) {
-				break
-			}
-			time.Sleep(5 * time.Second)
+			deploy = r.ActiveDeploy
 		}
 	}
-	d.Set("deploy_id", r.Body.ActiveDeploy.ID)
-	d.Set("args", r.Body.ActiveDeploy.Arguments)
-	d.Set("command", r.Body.ActiveDeploy.Command)
-	d.Set("envs", tagsFromMap(r.Body.ActiveDeploy.Env))
-
-	cpus := strconv.FormatFloat(r.Body.ActiveDeploy.Cpus, 'f', -1, 64)
-	memoryMb := strconv.FormatFloat(r.Body.ActiveDeploy.MemoryMb, 'f', -1, 64)
+	d.Set("deploy_id", deploy.ID)
+	d.Set("args", deploy.Arguments)
+	d.Set("command", deploy.Command)
+	d.Set("envs", tagsFromMap(deploy.Env))
 
-	resources := make(map[string]string)
-	for k, v := range map[string]string{
-		"cpus":      cpus,
-		"memory_mb": memoryMb,
-	} {
-		resources[k] = v
+	resources := flattenResources(deploy.SingularityDeployResources)
+	if err := d.Set("resources", resources); err != nil {
+		return fmt.Errorf("flatten resources from activeDeploy error: %v", err)
 	}
-	d.Set("resources", resources)
 
-	if r.Body.ActiveDeploy.Uris != nil {
+	if deploy.Uris != nil {
 		mapURI := make([]map[string]interface{}, 0)
-		for _, a := range r.Body.ActiveDeploy.Uris {
+		for _, a := range deploy.Uris {
 			m := make(map[string]interface{})
 			m["cache"] = a.Cache
 			m["path"] = a.URI
@@ -533,14 +1112,18 @@ func resourceDockerDeployRead(d *schema.ResourceData, m interface{}) error {
 		}
 		d.Set("uri", mapURI)
 	}
-	d.Set("metadata", r.Body.ActiveDeploy.Metadata)
+	d.Set("metadata", deploy.Metadata)
+	d.Set("skip_healthchecks_on_deploy", deploy.SkipHealthchecksOnDeploy)
+	if err := d.Set("healthcheck", flattenHealthcheck(deploy.Healthcheck)); err != nil {
+		return fmt.Errorf("flatten healthcheck from activeDeploy error: %v", err)
+	}
 
-	if err = d.Set("container_info", flattenContainerInfo(r.Body.ActiveDeploy.ContainerInfo)); err != nil {
+	if err = d.Set("container_info", flattenContainerInfo(deploy.ContainerInfo)); err != nil {
 		return fmt.Errorf("flatten docker_info from activeDeploy error: %v", err)
 	}
-	d.Set("args", r.Body.ActiveDeploy.Arguments)
+	d.Set("args", deploy.Arguments)
 	//}
-	d.Set("request_id", r.Body.SingularityRequest.ID)
+	d.Set("request_id", r.SingularityRequest.ID)
 	return nil
 }
 
@@ -556,9 +1139,22 @@ func flattenDockerInfo(in singularity.DockerInfo) []interface{} {
 	m["network"] = in.Network
 	m["image"] = in.Image
 	m["force_pull_image"] = in.ForcePullImage
+	m["privileged"] = in.Privileged
 	m["port_mapping"] = flattenDockerPortMappings(in.PortMappings)
+	m["parameter"] = flattenDockerParameters(in.SingularityDockerParameters)
 	return []interface{}{m}
 }
+
+func flattenDockerParameters(in []singularity.SingularityDockerParameter) []interface{} {
+	parameters := make([]interface{}, 0, len(in))
+	for _, p := range in {
+		parameters = append(parameters, map[string]interface{}{
+			"key":   p.Key,
+			"value": p.Value,
+		})
+	}
+	return parameters
+}
 func flattenContainerVolumes(in []singularity.SingularityVolume) *schema.Set {
 	s := schema.NewSet(containerVolumeHash, []interface{}{})
 	for _, v := range in {
@@ -614,27 +1210,52 @@ func flattenDockerPortMapping(v singularity.DockerPortMapping) map[string]interf
 
 func resourceDockerDeployUpdate(d *schema.ResourceData, m interface{}) error {
 
-	if d.HasChange("request_id") ||
-		d.HasChange("container_info") ||
-		d.HasChange("resources") ||
-		d.HasChange("args") ||
-		d.HasChange("command") ||
-		d.HasChange("envs") ||
-		d.HasChange("uri") {
+	for _, key := range deploySpecFields {
+		if !d.HasChange(key) {
+			continue
+		}
 		log.Printf("[INFO] Create new deploy with request id (%s): ***** %+v success", d.Id(), d)
 		// Singularity deploy is by design to be idempotent.
-		return createDockerDeploy(d, m)
+		ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutUpdate))
+		defer cancel()
+		return createDockerDeploy(ctx, d, m)
 	}
 	return nil
 }
 
+// resourceDockerDeployDelete only drops the deploy from state. Its request,
+// and whatever it runs, belong to the singularity_request resource. A deploy
+// still pending is cancelled, so it doesn't go live after it was destroyed.
+// With delete_request_on_destroy, the request is deleted instead.
 func resourceDockerDeployDelete(d *schema.ResourceData, m interface{}) error {
-	a := deleteRequest(d.Get("request_id").(string))
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutDelete))
+	defer cancel()
+	client := clientConn(m)
+	requestID := d.Get("request_id").(string)
+	if d.Get("delete_request_on_destroy").(bool) {
+		a := deleteRequest(ctx, requestID, singularity.SingularityDeleteRequest{
+			Message:  "Terraform detected changes",
+			ActionID: newActionID(),
+		})
+		d.SetId("")
+		return a(d, m)
+	}
+	r, err := getRequest(ctx, client, requestID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if p := r.PendingDeployState; err == nil && p != nil && p.DeployMarker.DeployID == d.Id() {
+		log.Printf("[INFO] Cancelling pending deploy %s of request %s", d.Id(), requestID)
+		if err := cancelDeploy(ctx, client, requestID, d.Id()); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
 	d.SetId("")
-	return a(d, m)
+	return nil
 }
 
 func resourceResourceDockerDeployImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	d.Set("wait_for_deploy", true)
 	if err := resourceDockerDeployRead(d, meta); err != nil {
 		return nil, err
 	}
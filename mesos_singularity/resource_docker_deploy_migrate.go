@@ -0,0 +1,52 @@
+package mesos_singularity
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// resourceDockerDeployV0 is singularity_docker_deploy before resources became
+// a block. Only resources differs, so it is built from the current schema.
+func resourceDockerDeployV0(current map[string]*schema.Schema) *schema.Resource {
+	s := make(map[string]*schema.Schema, len(current))
+	for k, v := range current {
+		s[k] = v
+	}
+	s["resources"] = &schema.Schema{
+		Type:     schema.TypeMap,
+		Optional: true,
+	}
+	return &schema.Resource{Schema: s}
+}
+
+// resourceDockerDeployStateUpgradeV0 moves resources from a map of strings,
+// e.g. {"cpus": "2"}, into a single resources block.
+func resourceDockerDeployStateUpgradeV0(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	old, _ := rawState["resources"].(map[string]interface{})
+	if len(old) == 0 {
+		rawState["resources"] = []interface{}{}
+		return rawState, nil
+	}
+
+	resources := map[string]interface{}{
+		"cpus":      0.0,
+		"memory_mb": 0.0,
+		"disk_mb":   0.0,
+		"num_ports": 0,
+	}
+	for _, k := range []string{"cpus", "memory_mb"} {
+		v, ok := old[k]
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade resources.%s %q of deploy %v: %v", k, v, rawState["id"], err)
+		}
+		resources[k] = f
+	}
+	rawState["resources"] = []interface{}{resources}
+	return rawState, nil
+}
@@ -0,0 +1,51 @@
+package mesos_singularity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResourceDockerDeployStateUpgradeV0(t *testing.T) {
+	var data = []struct {
+		resources interface{}
+		expect    []interface{}
+	}{
+		{
+			map[string]interface{}{"cpus": "2", "memory_mb": "128"},
+			[]interface{}{map[string]interface{}{
+				"cpus":      2.0,
+				"memory_mb": 128.0,
+				"disk_mb":   0.0,
+				"num_ports": 0,
+			}},
+		},
+		{
+			map[string]interface{}{"cpus": "0.5"},
+			[]interface{}{map[string]interface{}{
+				"cpus":      0.5,
+				"memory_mb": 0.0,
+				"disk_mb":   0.0,
+				"num_ports": 0,
+			}},
+		},
+		{map[string]interface{}{}, []interface{}{}},
+		{nil, []interface{}{}},
+	}
+
+	for _, tt := range data {
+		rawState := map[string]interface{}{"id": "foo", "resources": tt.resources}
+		actual, err := resourceDockerDeployStateUpgradeV0(rawState, nil)
+		if err != nil {
+			t.Errorf("upgrade %v: unexpected error %v", tt.resources, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual["resources"], tt.expect) {
+			t.Errorf("upgrade %v: expected %v, got %v", tt.resources, tt.expect, actual["resources"])
+		}
+	}
+
+	rawState := map[string]interface{}{"id": "foo", "resources": map[string]interface{}{"cpus": "two"}}
+	if _, err := resourceDockerDeployStateUpgradeV0(rawState, nil); err == nil {
+		t.Errorf("upgrade: expected an error for an unparseable cpus")
+	}
+}
@@ -1,10 +1,17 @@
 package mesos_singularity
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/terraform/config"
 	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
@@ -29,9 +36,9 @@ func TestAccSingularityDockerDeployCreateDefault(t *testing.T) {
 					resource.TestCheckResourceAttr(
 						"singularity_docker_deploy.foo", "container_info.0.docker_info.0.network", "BRIDGE"),
 					resource.TestCheckResourceAttr(
-						"singularity_docker_deploy.foo", "resources.cpus", "2"),
+						"singularity_docker_deploy.foo", "resources.0.cpus", "2"),
 					resource.TestCheckResourceAttr(
-						"singularity_docker_deploy.foo", "resources.memory_mb", "128"),
+						"singularity_docker_deploy.foo", "resources.0.memory_mb", "128"),
 					resource.TestCheckResourceAttr(
 						"singularity_docker_deploy.foo", "command", "bash"),
 					resource.TestCheckResourceAttr(
@@ -59,9 +66,9 @@ func TestAccSingularityDockerDeployCreateMaxOffer(t *testing.T) {
 					resource.TestCheckResourceAttr(
 						"singularity_docker_deploy.bar", "container_info.0.docker_info.0.network", "BRIDGE"),
 					resource.TestCheckResourceAttr(
-						"singularity_docker_deploy.bar", "resources.cpus", "2"),
+						"singularity_docker_deploy.bar", "resources.0.cpus", "2"),
 					resource.TestCheckResourceAttr(
-						"singularity_docker_deploy.bar", "resources.memory_mb", "128"),
+						"singularity_docker_deploy.bar", "resources.0.memory_mb", "128"),
 					resource.TestCheckResourceAttr(
 						"singularity_docker_deploy.bar", "command", "bash"),
 					resource.TestCheckResourceAttr(
@@ -96,9 +103,9 @@ func TestAccSingularityDockerDeployCreatePortMapping(t *testing.T) {
 					resource.TestCheckResourceAttr(
 						"singularity_docker_deploy.foobar", "container_info.0.docker_info.0.network", "BRIDGE"),
 					resource.TestCheckResourceAttr(
-						"singularity_docker_deploy.foobar", "resources.cpus", "2"),
+						"singularity_docker_deploy.foobar", "resources.0.cpus", "2"),
 					resource.TestCheckResourceAttr(
-						"singularity_docker_deploy.foobar", "resources.memory_mb", "128"),
+						"singularity_docker_deploy.foobar", "resources.0.memory_mb", "128"),
 					resource.TestCheckResourceAttr(
 						"singularity_docker_deploy.foobar", "command", "bash"),
 					resource.TestCheckResourceAttr(
@@ -133,9 +140,9 @@ func TestAccSingularityDockerDeployCreateVolumes(t *testing.T) {
 					resource.TestCheckResourceAttr(
 						"singularity_docker_deploy.foobaz", "container_info.0.docker_info.0.network", "BRIDGE"),
 					resource.TestCheckResourceAttr(
-						"singularity_docker_deploy.foobaz", "resources.cpus", "2"),
+						"singularity_docker_deploy.foobaz", "resources.0.cpus", "2"),
 					resource.TestCheckResourceAttr(
-						"singularity_docker_deploy.foobaz", "resources.memory_mb", "128"),
+						"singularity_docker_deploy.foobaz", "resources.0.memory_mb", "128"),
 					resource.TestCheckResourceAttr(
 						"singularity_docker_deploy.foobaz", "command", "bash"),
 					resource.TestCheckResourceAttr(
@@ -171,7 +178,7 @@ resource "singularity_docker_deploy" "foo" {
       image            = "ubuntu"
     }
   }
-  resources = {
+  resources {
     cpus      = 2
     memory_mb = 128
   }
@@ -202,7 +209,7 @@ resource "singularity_docker_deploy" "bar" {
     NAME  = "lenfree"
   }
 
-  resources = {
+  resources {
     cpus      = 2
     memory_mb = 128
   }
@@ -244,7 +251,7 @@ resource "singularity_docker_deploy" "foobar" {
     }
   }
 
-  resources = {
+  resources {
     cpus      = 2
     memory_mb = 128
   }
@@ -280,13 +287,77 @@ resource "singularity_docker_deploy" "foobaz" {
     }
   }
 
-  resources = {
+  resources {
     cpus      = 2
     memory_mb = 128
   }
 }
 `
 
+func TestAccSingularityDockerDeployCreateHealthcheck(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		Providers:    testAccProviders,
+		CheckDestroy: testCheckSingularityRequestDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckSingularityDeployDockerConfigHealthcheck,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr(
+						"singularity_docker_deploy.fooqux", "deploy_id", regexp.MustCompile("^nginx_[0-9a-f]{16}$")),
+					resource.TestCheckResourceAttr(
+						"singularity_docker_deploy.fooqux", "skip_healthchecks_on_deploy", "false"),
+					resource.TestCheckResourceAttr(
+						"singularity_docker_deploy.fooqux", "healthcheck.0.uri", "/"),
+					resource.TestCheckResourceAttr(
+						"singularity_docker_deploy.fooqux", "healthcheck.0.interval_seconds", "5"),
+					resource.TestCheckResourceAttr(
+						"singularity_docker_deploy.fooqux", "healthcheck.0.failure_status_codes.#", "2"),
+				),
+			},
+		},
+	})
+}
+
+const testAccCheckSingularityDeployDockerConfigHealthcheck = `
+resource "singularity_request" "fooqux" {
+  request_id             = "myrequestfooqux"
+  request_type           = "SERVICE"
+  instances              = 1
+}
+resource "singularity_docker_deploy" "fooqux" {
+  request_id                  = "${singularity_request.fooqux.id}"
+  deploy_id_prefix            = "nginx"
+  skip_healthchecks_on_deploy = false
+
+  container_info {
+    docker_info {
+      network          = "BRIDGE"
+      image            = "nginx"
+
+      port_mapping {
+        host_port           = 0
+        container_port      = 80
+        container_port_type = "LITERAL"
+        host_port_type      = "FROM_OFFER"
+        protocol            = "tcp"
+      }
+    }
+  }
+
+  healthcheck {
+    uri                  = "/"
+    interval_seconds     = 5
+    max_retries          = 3
+    failure_status_codes = [500, 503]
+  }
+
+  resources {
+    cpus      = 1
+    memory_mb = 128
+  }
+}
+`
+
 func testAccCheckSingularityDockerDeployExists(n string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
 		client := testAccProvider.Meta().(*Conn).sclient
@@ -372,6 +443,120 @@ func TestExpandPortMappings(t *testing.T) {
 	}
 }
 
+func TestExpandResources(t *testing.T) {
+	portMappings := []singularity.DockerPortMapping{
+		{HostPortType: "FROM_OFFER", HostPort: 0, ContainerPortType: "LITERAL", ContainerPort: 8080},
+		{HostPortType: "FROM_OFFER", HostPort: 1, ContainerPortType: "FROM_OFFER", ContainerPort: 1},
+		{HostPortType: "LITERAL", HostPort: 9000, ContainerPortType: "LITERAL", ContainerPort: 9000},
+	}
+	resources := func(numPorts int) []interface{} {
+		return []interface{}{map[string]interface{}{
+			"cpus":      0.5,
+			"memory_mb": 128.0,
+			"disk_mb":   1024.0,
+			"num_ports": numPorts,
+		}}
+	}
+	var data = []struct {
+		val          []interface{}
+		portMappings []singularity.DockerPortMapping
+		expectPorts  int64
+	}{
+		{resources(0), portMappings, 2},
+		{resources(0), nil, 0},
+		{resources(5), portMappings, 5},
+		// An explicit count is kept, customizeNumPorts rejects one too
+		// small.
+		{resources(1), portMappings, 1},
+		{nil, portMappings, 2},
+	}
+
+	for _, tt := range data {
+		actual := expandResources(tt.val, tt.portMappings)
+		if actual.NumPorts != tt.expectPorts {
+			t.Errorf("expandResources(%v): expected %d ports, got %d", tt.val, tt.expectPorts, actual.NumPorts)
+		}
+		if tt.val != nil && (actual.Cpus != 0.5 || actual.MemoryMb != 128 || actual.DiskMb != 1024) {
+			t.Errorf("expandResources(%v): unexpected resources %+v", tt.val, actual)
+		}
+		flattened := flattenResources(actual)
+		if tt.val != nil && !reflect.DeepEqual(flattened, resources(int(tt.expectPorts))) {
+			t.Errorf("flattenResources(%+v): expected num_ports %d, got %v", actual, tt.expectPorts, flattened)
+		}
+	}
+}
+
+func testPortMappingConfig(numPorts int) map[string]interface{} {
+	raw := testDeployConfig("", "ubuntu")
+	raw["container_info"] = []interface{}{map[string]interface{}{
+		"docker_info": []interface{}{map[string]interface{}{
+			"image": "ubuntu",
+			"port_mapping": []interface{}{
+				map[string]interface{}{"host_port_type": "FROM_OFFER", "host_port": 0, "container_port": 80},
+				map[string]interface{}{"host_port_type": "FROM_OFFER", "host_port": 2, "container_port": 443},
+			},
+		}},
+	}}
+	if numPorts > 0 {
+		raw["resources"] = []interface{}{map[string]interface{}{"cpus": 1, "num_ports": numPorts}}
+	}
+	return raw
+}
+
+func TestCustomizeNumPorts(t *testing.T) {
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer ts.Close()
+
+	var data = []struct {
+		numPorts  int
+		expectErr string
+	}{
+		{0, ""},
+		{3, ""},
+		{5, ""},
+		{2, "container_port 443 to host_port 2"},
+	}
+	for _, tt := range data {
+		c, err := config.NewRawConfig(testPortMappingConfig(tt.numPorts))
+		if err != nil {
+			t.Fatalf("config error: %v", err)
+		}
+		_, err = resourceDockerDeploy().Diff(nil, terraform.NewResourceConfig(c), conn)
+		if tt.expectErr == "" && err != nil {
+			t.Errorf("Diff(num_ports = %d): %v", tt.numPorts, err)
+		}
+		if tt.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectErr)) {
+			t.Errorf("Diff(num_ports = %d): expected an error naming %q, got %v", tt.numPorts, tt.expectErr, err)
+		}
+	}
+}
+
+func TestSuppressDerivedNumPortsDiff(t *testing.T) {
+	var data = []struct {
+		numPorts int
+		old      string
+		new      string
+		expect   bool
+	}{
+		// Unset, and Singularity gave the deploy the derived count.
+		{0, "3", "0", true},
+		{0, "3", "", true},
+		// Unset after an explicit count, or the port mappings changed.
+		{0, "5", "0", false},
+		{0, "2", "0", false},
+		{5, "3", "5", false},
+	}
+	for _, tt := range data {
+		d := schema.TestResourceDataRaw(t, resourceDockerDeploy().Schema, testPortMappingConfig(tt.numPorts))
+		actual := suppressDerivedNumPortsDiff("resources.0.num_ports", tt.old, tt.new, d)
+		if actual != tt.expect {
+			t.Errorf("suppressDerivedNumPortsDiff(%s, %s): expected %v, got %v", tt.old, tt.new, tt.expect, actual)
+		}
+	}
+}
+
 func TestExpandDockerVolumes(t *testing.T) {
 	volumes := []struct {
 		val    []interface{}
@@ -440,3 +625,563 @@ func TestExpandUris(t *testing.T) {
 		}
 	}
 }
+
+func TestExpandHealthcheck(t *testing.T) {
+	if h := expandHealthcheck(nil); h != nil {
+		t.Errorf("expected no healthcheck without a block, got %+v", h)
+	}
+
+	h := expandHealthcheck([]interface{}{
+		map[string]interface{}{
+			"uri":                      "/health",
+			"protocol":                 "HTTPS",
+			"port_index":               1,
+			"port_number":              0,
+			"startup_delay_seconds":    10,
+			"startup_timeout_seconds":  60,
+			"startup_interval_seconds": 0,
+			"interval_seconds":         5,
+			"response_timeout_seconds": 2,
+			"max_retries":              3,
+			"failure_status_codes":     []interface{}{500, 503},
+		},
+	})
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unset fields are left to Singularity's defaults.
+	expect := `{"uri":"/health","protocol":"HTTPS","portIndex":1,"startupDelaySeconds":10,"startupTimeoutSeconds":60,` +
+		`"intervalSeconds":5,"responseTimeoutSeconds":2,"maxRetries":3,"failureStatusCodes":[500,503]}`
+	if string(b) != expect {
+		t.Errorf("expected %s, got %s", expect, b)
+	}
+}
+
+func TestCustomizeHealthcheck(t *testing.T) {
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer ts.Close()
+
+	deployed := func(skip string, healthcheck bool) *terraform.InstanceState {
+		attrs := map[string]string{
+			"id":                          "bar",
+			"request_id":                  "foo",
+			"skip_healthchecks_on_deploy": skip,
+			"healthcheck.#":               "0",
+		}
+		if healthcheck {
+			attrs["healthcheck.#"] = "1"
+			attrs["healthcheck.0.uri"] = "/health"
+		}
+		return &terraform.InstanceState{ID: "bar", Attributes: attrs}
+	}
+	var data = []struct {
+		state       *terraform.InstanceState
+		healthcheck bool
+		skip        interface{}
+		expectErr   bool
+		expectSkip  string
+	}{
+		{nil, false, nil, false, "true"},
+		{nil, true, nil, false, "false"},
+		{nil, true, false, false, "false"},
+		{nil, false, false, false, "false"},
+		{nil, true, true, true, ""},
+		// A healthcheck added later gates deploys too.
+		{deployed("true", false), true, nil, false, "false"},
+		// Deployed with the old default of true.
+		{deployed("true", true), true, nil, false, "false"},
+		{deployed("false", true), true, nil, false, "false"},
+		{deployed("false", true), true, true, true, ""},
+		{deployed("false", true), false, nil, false, "true"},
+	}
+	for i, tt := range data {
+		raw := testDeployConfig("", "ubuntu")
+		if tt.healthcheck {
+			raw["healthcheck"] = []interface{}{map[string]interface{}{"uri": "/health"}}
+		}
+		if tt.skip != nil {
+			raw["skip_healthchecks_on_deploy"] = tt.skip
+		}
+		c, err := config.NewRawConfig(raw)
+		if err != nil {
+			t.Fatalf("config error: %v", err)
+		}
+		diff, err := resourceDockerDeploy().Diff(tt.state, terraform.NewResourceConfig(c), conn)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("Diff %d: expected error %v, got %v", i, tt.expectErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		skip := ""
+		if tt.state != nil {
+			skip = tt.state.Attributes["skip_healthchecks_on_deploy"]
+		}
+		if attr := diff.Attributes["skip_healthchecks_on_deploy"]; attr != nil {
+			skip = attr.New
+		}
+		if skip != tt.expectSkip {
+			t.Errorf("Diff %d: expected skip_healthchecks_on_deploy %s, got %s", i, tt.expectSkip, skip)
+		}
+	}
+}
+
+func TestResourceDockerDeployReadHealthcheck(t *testing.T) {
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "ACTIVE",
+			"activeDeploy": {"id": "bar", "requestId": "foo", "skipHealthchecksOnDeploy": true,
+				"containerInfo": {"type": "DOCKER", "docker": {"image": "ubuntu"}},
+				"healthcheck": {"uri": "/health", "protocol": "HTTP", "intervalSeconds": 5,
+					"failureStatusCodes": [500]}}}`))
+	})
+	defer ts.Close()
+	d := resourceDockerDeploy().TestResourceData()
+	d.SetId("bar")
+	d.Set("request_id", "foo")
+	if err := resourceDockerDeployRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	for k, v := range map[string]interface{}{
+		"skip_healthchecks_on_deploy":          true,
+		"healthcheck.0.uri":                    "/health",
+		"healthcheck.0.protocol":               "HTTP",
+		"healthcheck.0.interval_seconds":       5,
+		"healthcheck.0.failure_status_codes.0": 500,
+	} {
+		if got := d.Get(k); got != v {
+			t.Errorf("Read: expected %s %v, got %v", k, v, got)
+		}
+	}
+}
+
+func TestDockerInfoRoundTrip(t *testing.T) {
+	parameters := []interface{}{
+		map[string]interface{}{"key": "cap-add", "value": "NET_ADMIN"},
+		map[string]interface{}{"key": "ulimit", "value": "nofile=65536:65536"},
+		map[string]interface{}{"key": "cap-add", "value": "SYS_PTRACE"},
+		map[string]interface{}{"key": "label", "value": "team=platform"},
+	}
+	in := map[string]interface{}{
+		"docker_info": []interface{}{
+			map[string]interface{}{
+				"image":            "ubuntu",
+				"network":          "HOST",
+				"force_pull_image": true,
+				"privileged":       true,
+				"port_mapping":     schema.NewSet(portMappingHash, nil),
+				"parameter":        parameters,
+			},
+		},
+	}
+	info := expandDockerInfo(in)
+	if !info.Privileged {
+		t.Errorf("expected privileged to be set")
+	}
+	expect := []singularity.SingularityDockerParameter{
+		{Key: "cap-add", Value: "NET_ADMIN"},
+		{Key: "ulimit", Value: "nofile=65536:65536"},
+		{Key: "cap-add", Value: "SYS_PTRACE"},
+		{Key: "label", Value: "team=platform"},
+	}
+	if !reflect.DeepEqual(info.SingularityDockerParameters, expect) {
+		t.Errorf("expected parameters %+v, got %+v", expect, info.SingularityDockerParameters)
+	}
+
+	out := flattenDockerInfo(info)[0].(map[string]interface{})
+	if out["privileged"] != true {
+		t.Errorf("flatten: expected privileged true, got %v", out["privileged"])
+	}
+	if !reflect.DeepEqual(out["parameter"], parameters) {
+		t.Errorf("flatten: expected parameters %v, got %v", parameters, out["parameter"])
+	}
+}
+
+func testDeployConfig(prefix, image string) map[string]interface{} {
+	return map[string]interface{}{
+		"request_id":       "foo",
+		"deploy_id_prefix": prefix,
+		"command":          "bash",
+		"envs":             map[string]interface{}{"B": "2", "A": "1"},
+		"container_info": []interface{}{map[string]interface{}{
+			"docker_info": []interface{}{map[string]interface{}{"image": image}},
+		}},
+	}
+}
+
+func testDeployResourceData(t *testing.T, prefix, image string) *schema.ResourceData {
+	return schema.TestResourceDataRaw(t, resourceDockerDeploy().Schema, testDeployConfig(prefix, image))
+}
+
+func TestDeployID(t *testing.T) {
+	id := func(prefix, image string) string {
+		d := testDeployResourceData(t, prefix, image)
+		id, err := deployID(d.Get("deploy_id_prefix").(string), buildDeployRequest(d).Deploy)
+		if err != nil {
+			t.Fatalf("deployID: %v", err)
+		}
+		return id
+	}
+
+	base := id("", "ubuntu")
+	if base != id("", "ubuntu") {
+		t.Errorf("expected the same spec to get the same ID")
+	}
+	if base == id("", "nginx") {
+		t.Errorf("expected a different spec to get a different ID")
+	}
+	if !deployIDPrefixPattern.MatchString(base) || len(base) != deployIDHashLength {
+		t.Errorf("unexpected deploy ID %q", base)
+	}
+	if prefixed := id("api_v2", "ubuntu"); prefixed != "api_v2_"+base {
+		t.Errorf("expected prefixed ID %q, got %q", "api_v2_"+base, prefixed)
+	}
+	long := id(strings.Repeat("a", maxDeployIDPrefixLength), "ubuntu")
+	if retry := fmt.Sprintf("%s_%d", long, maxDeployAttempts); len(retry) != maxDeployIDLength {
+		t.Errorf("expected the longest ID to be %d characters, got %d", maxDeployIDLength, len(retry))
+	}
+
+	// Sets are hashed in a fixed order.
+	deploy := buildDeployRequest(testDeployResourceData(t, "", "ubuntu")).Deploy
+	deploy.ContainerInfo.DockerInfo.PortMappings = []singularity.DockerPortMapping{
+		{HostPortType: "FROM_OFFER", HostPort: 0, ContainerPort: 80},
+		{HostPortType: "FROM_OFFER", HostPort: 1, ContainerPort: 443},
+	}
+	first, _ := deployID("", deploy)
+	pm := deploy.ContainerInfo.DockerInfo.PortMappings
+	pm[0], pm[1] = pm[1], pm[0]
+	if second, _ := deployID("", deploy); first != second {
+		t.Errorf("expected the port mapping order not to change the ID, got %q and %q", first, second)
+	}
+}
+
+func TestNextDeployID(t *testing.T) {
+	var history []string
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for _, id := range history {
+			if r.URL.Path == "/api/history/request/foo/deploy/"+id {
+				fmt.Fprintf(w, `{"deployMarker": {"requestId": "foo", "deployId": %q},
+					"deployResult": {"deployState": "FAILED"}}`, id)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer ts.Close()
+
+	var data = []struct {
+		history []string
+		active  string
+		expect  string
+	}{
+		{nil, "", "api_1234"},
+		{[]string{"api_1234"}, "api_1234", "api_1234"},
+		// A failed deploy is retried with the next attempt.
+		{[]string{"api_1234"}, "", "api_1234_2"},
+		{[]string{"api_1234", "api_1234_2"}, "", "api_1234_3"},
+		{[]string{"api_1234", "api_1234_2"}, "api_1234_2", "api_1234_2"},
+	}
+	for _, tt := range data {
+		history = tt.history
+		r := requestParent{}
+		r.SingularityRequest.ID = "foo"
+		r.ActiveDeploy.ID = tt.active
+		id, err := nextDeployID(context.Background(), clientConn(conn), r, "api_1234")
+		if err != nil {
+			t.Fatalf("nextDeployID(%v): %v", tt.history, err)
+		}
+		if id != tt.expect {
+			t.Errorf("nextDeployID(%v, active %q): expected %q, got %q", tt.history, tt.active, tt.expect, id)
+		}
+	}
+}
+
+func TestCustomizeDeployID(t *testing.T) {
+	// Without a healthcheck, the plan skips healthchecks.
+	d := testDeployResourceData(t, "api", "ubuntu")
+	d.Set("skip_healthchecks_on_deploy", true)
+	base, err := deployID("api", buildDeployRequest(d).Deploy)
+	if err != nil {
+		t.Fatalf("deployID: %v", err)
+	}
+	var requestExists bool
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case !requestExists:
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/api/requests/request/foo":
+			w.Write([]byte(`{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "ACTIVE"}`))
+		case r.URL.Path == "/api/history/request/foo/deploy/"+base:
+			w.Write([]byte(`{"deployResult": {"deployState": "FAILED"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer ts.Close()
+
+	var data = []struct {
+		requestExists bool
+		expect        string
+	}{
+		{false, base},
+		// The failed deploy is retried under the next ID.
+		{true, base + "_2"},
+	}
+	for _, tt := range data {
+		requestExists = tt.requestExists
+		c, err := config.NewRawConfig(testDeployConfig("api", "ubuntu"))
+		if err != nil {
+			t.Fatalf("config error: %v", err)
+		}
+		diff, err := resourceDockerDeploy().Diff(nil, terraform.NewResourceConfig(c), conn)
+		if err != nil {
+			t.Fatalf("Diff: %v", err)
+		}
+		if attr := diff.Attributes["deploy_id"]; attr == nil || attr.NewComputed || attr.New != tt.expect {
+			t.Errorf("Diff with request %v: expected deploy_id %q, got %+v", tt.requestExists, tt.expect, attr)
+		}
+	}
+}
+
+func TestCustomizeDeployIDTimeout(t *testing.T) {
+	timeout := planTimeout
+	planTimeout = 50 * time.Millisecond
+	defer func() { planTimeout = timeout }()
+
+	var calls int
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/requests/request/foo" {
+			w.Write([]byte(`{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "ACTIVE"}`))
+			return
+		}
+		// Every deploy ID was tried before, and the history is slow.
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"deployResult": {"deployState": "FAILED"}}`))
+	})
+	defer ts.Close()
+
+	c, err := config.NewRawConfig(testDeployConfig("api", "ubuntu"))
+	if err != nil {
+		t.Fatalf("config error: %v", err)
+	}
+	_, err = resourceDockerDeploy().Diff(nil, terraform.NewResourceConfig(c), conn)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Diff: expected a timeout, got %v", err)
+	}
+	if calls > maxDeployAttempts/2 {
+		t.Errorf("Diff: expected the lookup to stop at the timeout, got %d calls", calls)
+	}
+}
+
+func TestCreateDockerDeployAlreadyActive(t *testing.T) {
+	d := testDeployResourceData(t, "", "ubuntu")
+	id, err := deployID("", buildDeployRequest(d).Deploy)
+	if err != nil {
+		t.Fatalf("deployID: %v", err)
+	}
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected call %s %s", r.Method, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "ACTIVE",
+			"activeDeploy": {"id": %q, "requestId": "foo",
+				"containerInfo": {"type": "DOCKER", "docker": {"image": "ubuntu"}}}}`, id)
+	})
+	defer ts.Close()
+	if err := createDockerDeploy(context.Background(), d, conn); err != nil {
+		t.Fatalf("createDockerDeploy: %v", err)
+	}
+	if d.Id() != id || d.Get("deploy_id") != id {
+		t.Errorf("expected deploy %q, got ID %q and deploy_id %q", id, d.Id(), d.Get("deploy_id"))
+	}
+}
+
+func TestDeployResultError(t *testing.T) {
+	var data = []struct {
+		result    deployResult
+		progress  deployProgress
+		expectErr string
+	}{
+		{deployResult{DeployState: "SUCCEEDED"}, deployProgress{}, ""},
+		{
+			deployResult{
+				DeployState: "FAILED",
+				Message:     "Task failed healthchecks",
+				DeployFailures: []deployFailure{
+					{Reason: "TASK_FAILED_HEALTH_CHECKS", TaskID: &taskID{ID: "task1"}, Message: "status 500"},
+					{Reason: "TASK_EXPECTED_RUNNING_FINISHED"},
+				},
+			},
+			deployProgress{FailedDeployTasks: []taskID{{ID: "task1"}, {ID: "task2"}}},
+			"deploy bar of request foo ended FAILED: Task failed healthchecks; " +
+				"TASK_FAILED_HEALTH_CHECKS on task task1: status 500; TASK_EXPECTED_RUNNING_FINISHED " +
+				"(failed tasks: task1, task2)",
+		},
+		{
+			deployResult{DeployState: "OVERDUE", Message: "Deploy was overdue"},
+			deployProgress{},
+			"deploy bar of request foo ended OVERDUE: Deploy was overdue",
+		},
+		{deployResult{DeployState: "CANCELED"}, deployProgress{}, "deploy bar of request foo ended CANCELED"},
+	}
+
+	for _, tt := range data {
+		result := tt.result
+		err := deployResultError("foo", "bar", &result, tt.progress)
+		switch {
+		case tt.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.result.DeployState, err)
+		case tt.expectErr != "" && (err == nil || err.Error() != tt.expectErr):
+			t.Errorf("%s: expected error %q, got %v", tt.result.DeployState, tt.expectErr, err)
+		}
+	}
+}
+
+func TestWaitForDeployResult(t *testing.T) {
+	var data = []struct {
+		history   string
+		expectErr string
+	}{
+		{`{"deployResult": {"deployState": "SUCCEEDED"}}`, ""},
+		{
+			`{"deployResult": {"deployState": "FAILED_INTERNAL_STATE", "message": "Mesos lost the task",
+				"deployFailures": [{"reason": "TASK_LOST", "taskId": {"id": "task1"}}]}}`,
+			"deploy bar of request foo ended FAILED_INTERNAL_STATE: Mesos lost the task; " +
+				"TASK_LOST on task task1 (failed tasks: task1)",
+		},
+	}
+
+	for _, tt := range data {
+		conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch r.URL.Path {
+			case "/api/requests/request/foo":
+				w.Write([]byte(`{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "ACTIVE"}`))
+			case "/api/history/request/foo/deploy/bar":
+				w.Write([]byte(tt.history))
+			default:
+				t.Errorf("unexpected call %s %s", r.Method, r.URL.Path)
+			}
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := waitForDeployResult(ctx, conn.sclient, "foo", "bar")
+		cancel()
+		ts.Close()
+		switch {
+		case tt.expectErr == "" && err != nil:
+			t.Errorf("unexpected error %v", err)
+		case tt.expectErr != "" && (err == nil || err.Error() != tt.expectErr):
+			t.Errorf("expected error %q, got %v", tt.expectErr, err)
+		}
+	}
+}
+
+func TestResourceDockerDeployReadPendingDeploy(t *testing.T) {
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "ACTIVE",
+			"requestDeployState": {"pendingDeploy": {"deployId": "bar"}},
+			"activeDeploy": {"id": "old", "requestId": "foo", "command": "old",
+				"containerInfo": {"type": "DOCKER", "docker": {"image": "ubuntu"}}},
+			"pendingDeploy": {"id": "bar", "requestId": "foo", "command": "new",
+				"containerInfo": {"type": "DOCKER", "docker": {"image": "ubuntu"}}},
+			"pendingDeployState": {"currentDeployState": "WAITING",
+				"deployMarker": {"requestId": "foo", "deployId": "bar"}}}`))
+	})
+	defer ts.Close()
+	d := resourceDockerDeploy().TestResourceData()
+	d.SetId("bar")
+	d.Set("request_id", "foo")
+	d.Set("wait_for_deploy", false)
+	if err := resourceDockerDeployRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if d.Get("deploy_id") != "bar" || d.Get("command") != "new" {
+		t.Errorf("Read: expected the pending deploy, got deploy_id %v and command %v",
+			d.Get("deploy_id"), d.Get("command"))
+	}
+}
+
+func TestResourceDockerDeployPaused(t *testing.T) {
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "PAUSED",
+			"requestDeployState": {"pendingDeploy": {"deployId": "bar"}},
+			"activeDeploy": {"id": "old", "requestId": "foo", "command": "old",
+				"containerInfo": {"type": "DOCKER", "docker": {"image": "ubuntu"}}},
+			"pendingDeploy": {"id": "bar", "requestId": "foo", "command": "new",
+				"containerInfo": {"type": "DOCKER", "docker": {"image": "ubuntu"}}}}`))
+	})
+	defer ts.Close()
+
+	// A paused request never rolls out its pending deploy, so reads do not
+	// wait for it.
+	for _, id := range []string{"bar", "old"} {
+		d := resourceDockerDeploy().TestResourceData()
+		d.SetId(id)
+		d.Set("request_id", "foo")
+		d.Set("wait_for_deploy", true)
+		exists, err := resourceDockerDeployExists(d, conn)
+		if err != nil || !exists {
+			t.Fatalf("Exists(%s): expected true, got %v, %v", id, exists, err)
+		}
+		if err := resourceDockerDeployRead(d, conn); err != nil {
+			t.Fatalf("Read(%s): %v", id, err)
+		}
+		if d.Get("deploy_id") != id {
+			t.Errorf("Read(%s): expected deploy_id %s, got %v", id, id, d.Get("deploy_id"))
+		}
+	}
+}
+
+func TestResourceDockerDeployDelete(t *testing.T) {
+	var calls []string
+	var pending string
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			fmt.Fprintf(w, `{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "ACTIVE",
+				"pendingDeployState": {"currentDeployState": "WAITING",
+					"deployMarker": {"requestId": "foo", "deployId": %q}}}`, pending)
+		}
+	})
+	defer ts.Close()
+
+	var data = []struct {
+		pending       string
+		deleteRequest bool
+		expect        []string
+	}{
+		// The request and its active deploy are left alone.
+		{"other", false, []string{"GET /api/requests/request/foo"}},
+		// A pending deploy is cancelled.
+		{"bar", false, []string{"GET /api/requests/request/foo", "DELETE /api/deploys/deploy/bar/request/foo"}},
+		// The request is deleted when asked to.
+		{"other", true, []string{"DELETE /api/requests/request/foo"}},
+	}
+	for _, tt := range data {
+		calls, pending = nil, tt.pending
+		d := resourceDockerDeploy().TestResourceData()
+		d.SetId("bar")
+		d.Set("request_id", "foo")
+		d.Set("delete_request_on_destroy", tt.deleteRequest)
+		if err := resourceDockerDeployDelete(d, conn); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(tt.expect) {
+			t.Errorf("Delete with pending deploy %s: expected calls %v, got %v", tt.pending, tt.expect, calls)
+		}
+		if d.Id() != "" {
+			t.Errorf("Delete: expected the ID to be cleared, got %q", d.Id())
+		}
+	}
+}
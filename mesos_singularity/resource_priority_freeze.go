@@ -0,0 +1,172 @@
+package mesos_singularity
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// priorityFreezeID is the ID of the singularity_priority_freeze resource.
+// Singularity has at most one freeze at a time.
+const priorityFreezeID = "priority-freeze"
+
+func resourcePriorityFreeze() *schema.Resource {
+	return &schema.Resource{
+		Create: resourcePriorityFreezeCreate,
+		Read:   resourcePriorityFreezeRead,
+		Update: resourcePriorityFreezeUpdate,
+		Delete: resourcePriorityFreezeDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"minimum_priority_level": &schema.Schema{
+				Type:         schema.TypeFloat,
+				Required:     true,
+				ValidateFunc: validatePriorityLevel,
+			},
+			"kill_tasks": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"message": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			// timestamp is when the freeze this resource set was created.
+			// Every freeze gets a new one, so it tells this resource's
+			// freeze apart from one set outside Terraform.
+			"timestamp": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourcePriorityFreezeCreate(d *schema.ResourceData, m interface{}) error {
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutCreate))
+	defer cancel()
+	// Creating a freeze replaces the active one, which this resource does
+	// not own.
+	r, err := getPriorityFreeze(ctx, clientConn(m))
+	if err == nil {
+		return fmt.Errorf("a priority freeze below %v set by %q is already active, import it with ID %q instead",
+			r.PriorityFreeze.MinimumPriorityLevel, r.User, priorityFreezeID)
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("read priority freeze error: %v", err)
+	}
+	if err := setPriorityFreeze(ctx, d, m); err != nil {
+		return err
+	}
+	d.SetId(priorityFreezeID)
+	return readPriorityFreeze(ctx, d, m)
+}
+
+// resourcePriorityFreezeUpdate replaces this resource's freeze with the new
+// settings in one call, so priorities are never unfrozen in between.
+func resourcePriorityFreezeUpdate(d *schema.ResourceData, m interface{}) error {
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutUpdate))
+	defer cancel()
+	r, err := getPriorityFreeze(ctx, clientConn(m))
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("read priority freeze error: %v", err)
+	}
+	if err == nil && int64(d.Get("timestamp").(int)) != r.Timestamp {
+		return fmt.Errorf("the active priority freeze was replaced by %q outside Terraform, "+
+			"refresh before changing it", r.User)
+	}
+	if err := setPriorityFreeze(ctx, d, m); err != nil {
+		return err
+	}
+	return readPriorityFreeze(ctx, d, m)
+}
+
+// setPriorityFreeze freezes priorities with the configured settings and
+// records the new freeze's timestamp.
+func setPriorityFreeze(ctx context.Context, d *schema.ResourceData, m interface{}) error {
+	req := priorityFreeze{
+		MinimumPriorityLevel: d.Get("minimum_priority_level").(float64),
+		KillTasks:            d.Get("kill_tasks").(bool),
+		Message:              d.Get("message").(string),
+		ActionID:             newActionID(),
+	}
+	log.Printf("[INFO] Freezing task priorities below %v", req.MinimumPriorityLevel)
+	r, err := createPriorityFreeze(ctx, clientConn(m), req)
+	if err != nil {
+		return err
+	}
+	d.Set("timestamp", r.Timestamp)
+	return nil
+}
+
+func resourcePriorityFreezeRead(d *schema.ResourceData, m interface{}) error {
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutRead))
+	defer cancel()
+	return readPriorityFreeze(ctx, d, m)
+}
+
+// readPriorityFreeze reads the active freeze. A freeze other than the one
+// this resource set means its freeze is gone. An imported resource, which
+// has no timestamp yet, takes on the active freeze.
+func readPriorityFreeze(ctx context.Context, d *schema.ResourceData, m interface{}) error {
+	r, err := getPriorityFreeze(ctx, clientConn(m))
+	if isNotFound(err) {
+		log.Printf("[WARN] No priority freeze is active, removing it from state")
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read priority freeze error: %v", err)
+	}
+	if own := int64(d.Get("timestamp").(int)); own != 0 && own != r.Timestamp {
+		log.Printf("[WARN] The priority freeze was replaced by %q, removing it from state", r.User)
+		d.SetId("")
+		return nil
+	}
+	d.SetId(priorityFreezeID)
+	d.Set("timestamp", r.Timestamp)
+	d.Set("minimum_priority_level", r.PriorityFreeze.MinimumPriorityLevel)
+	d.Set("kill_tasks", r.PriorityFreeze.KillTasks)
+	d.Set("message", r.PriorityFreeze.Message)
+	return nil
+}
+
+// resourcePriorityFreezeDelete lifts the freeze, unless it was lifted or
+// replaced by another one.
+func resourcePriorityFreezeDelete(d *schema.ResourceData, m interface{}) error {
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutDelete))
+	defer cancel()
+	r, err := getPriorityFreeze(ctx, clientConn(m))
+	if isNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read priority freeze error: %v", err)
+	}
+	if r.Timestamp != int64(d.Get("timestamp").(int)) {
+		log.Printf("[WARN] The active priority freeze was replaced by %q, leaving it in place", r.User)
+		d.SetId("")
+		return nil
+	}
+	log.Printf("[INFO] Lifting priority freeze")
+	if err := deletePriorityFreeze(ctx, clientConn(m)); err != nil && !isNotFound(err) {
+		return err
+	}
+	d.SetId("")
+	return nil
+}
@@ -0,0 +1,184 @@
+package mesos_singularity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestAccSingularityPriorityFreeze(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testCheckSingularityPriorityFreezeDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckSingularityPriorityFreezeConfig,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"singularity_priority_freeze.incident", "minimum_priority_level", "0.5"),
+					resource.TestCheckResourceAttr(
+						"singularity_priority_freeze.incident", "kill_tasks", "false"),
+					resource.TestCheckResourceAttr(
+						"singularity_priority_freeze.incident", "message", "capacity incident"),
+				),
+			},
+			{
+				Config: testAccCheckSingularityPriorityFreezeConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(
+						"singularity_priority_freeze.incident", "minimum_priority_level", "0.25"),
+				),
+			},
+			{
+				ResourceName:      "singularity_priority_freeze.incident",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+const testAccCheckSingularityPriorityFreezeConfig = `
+resource "singularity_priority_freeze" "incident" {
+			minimum_priority_level = 0.5
+			message                = "capacity incident"
+}
+`
+
+const testAccCheckSingularityPriorityFreezeConfigUpdate = `
+resource "singularity_priority_freeze" "incident" {
+			minimum_priority_level = 0.25
+			message                = "capacity incident"
+}
+`
+
+func testCheckSingularityPriorityFreezeDestroy(state *terraform.State) error {
+	client := testAccProvider.Meta().(*Conn).sclient
+	if _, err := getPriorityFreeze(context.Background(), client); !isNotFound(err) {
+		return fmt.Errorf("priority freeze still active: %v", err)
+	}
+	return nil
+}
+
+func TestResourcePriorityFreeze(t *testing.T) {
+	var freeze *priorityFreezeParent
+	var timestamp int64
+	var calls []string
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/priority/freeze" {
+			t.Errorf("unexpected call %s %s", r.Method, r.URL.Path)
+		}
+		switch r.Method {
+		case http.MethodPost:
+			timestamp++
+			freeze = &priorityFreezeParent{Timestamp: timestamp, User: "terraform"}
+			if err := json.NewDecoder(r.Body).Decode(&freeze.PriorityFreeze); err != nil {
+				t.Errorf("decode freeze: %v", err)
+			}
+		case http.MethodDelete:
+			freeze = nil
+			return
+		}
+		if freeze == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(freeze)
+	})
+	defer ts.Close()
+
+	d := resourcePriorityFreeze().TestResourceData()
+	d.Set("minimum_priority_level", 0.75)
+	d.Set("kill_tasks", true)
+	d.Set("message", "capacity incident")
+	if err := resourcePriorityFreezeCreate(d, conn); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if d.Id() != priorityFreezeID {
+		t.Errorf("Create: expected ID %q, got %q", priorityFreezeID, d.Id())
+	}
+	if freeze == nil || freeze.PriorityFreeze.MinimumPriorityLevel != 0.75 || !freeze.PriorityFreeze.KillTasks ||
+		freeze.PriorityFreeze.ActionID == "" {
+		t.Errorf("Create: unexpected freeze %+v", freeze)
+	}
+	if v := d.Get("timestamp").(int); v != 1 {
+		t.Errorf("Create: expected timestamp 1, got %d", v)
+	}
+
+	// A freeze is only created while none is active.
+	if err := resourcePriorityFreezeCreate(resourcePriorityFreeze().TestResourceData(), conn); err == nil {
+		t.Errorf("Create: expected an error while a freeze is active")
+	}
+
+	// A change replaces the freeze without lifting it first.
+	calls = nil
+	d.Set("minimum_priority_level", 0.25)
+	if err := resourcePriorityFreezeUpdate(d, conn); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if fmt.Sprint(calls) != "[GET POST GET]" {
+		t.Errorf("Update: expected calls [GET POST GET], got %v", calls)
+	}
+	if freeze.PriorityFreeze.MinimumPriorityLevel != 0.25 || d.Get("timestamp").(int) != 2 {
+		t.Errorf("Update: unexpected freeze %+v with timestamp %v", freeze, d.Get("timestamp"))
+	}
+
+	// A freeze set outside Terraform is not this resource's, even after a
+	// refresh.
+	other := priorityFreezeParent{
+		PriorityFreeze: priorityFreeze{MinimumPriorityLevel: 0.5},
+		Timestamp:      100,
+		User:           "oncall",
+	}
+	freeze = &other
+	if err := resourcePriorityFreezeUpdate(d, conn); err == nil {
+		t.Errorf("Update: expected an error for a replaced freeze")
+	}
+	if err := resourcePriorityFreezeDelete(d, conn); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if freeze == nil {
+		t.Errorf("Delete: expected the replaced freeze to be kept")
+	}
+	d.SetId(priorityFreezeID)
+	if err := resourcePriorityFreezeRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if d.Id() != "" {
+		t.Errorf("Read: expected a replaced freeze to clear the ID, got %q", d.Id())
+	}
+
+	// An imported resource takes on the active freeze, and lifts it.
+	d = resourcePriorityFreeze().TestResourceData()
+	d.SetId(priorityFreezeID)
+	if err := resourcePriorityFreezeRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if d.Id() != priorityFreezeID || d.Get("timestamp").(int) != 100 ||
+		d.Get("minimum_priority_level").(float64) != 0.5 {
+		t.Errorf("Read: expected the imported freeze, got timestamp %v and minimum_priority_level %v",
+			d.Get("timestamp"), d.Get("minimum_priority_level"))
+	}
+	if err := resourcePriorityFreezeDelete(d, conn); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if freeze != nil {
+		t.Errorf("Delete: expected the freeze to be lifted, got %+v", freeze)
+	}
+
+	d.SetId(priorityFreezeID)
+	if err := resourcePriorityFreezeRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if d.Id() != "" {
+		t.Errorf("Read: expected a lifted freeze to clear the ID, got %q", d.Id())
+	}
+}
@@ -1,11 +1,14 @@
 package mesos_singularity
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
+	"text/template"
 	"time"
 
+	"github.com/hashicorp/terraform/helper/customdiff"
 	"github.com/hashicorp/terraform/helper/schema"
 	singularity "github.com/lenfree/go-singularity"
 )
@@ -17,10 +20,22 @@ func resourceRequest() *schema.Resource {
 		Exists: resourceRequestExists,
 		Update: resourceRequestUpdate,
 		Delete: resourceRequestDelete,
+		CustomizeDiff: customdiff.Sequence(
+			customizeRequestDeletionProtection,
+			customizeRequestType,
+			customizeRequestSchedule,
+		),
 		Importer: &schema.ResourceImporter{
 			State: resourceResourceRequestImport,
 		},
 
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+		},
+
 		Schema: map[string]*schema.Schema{
 			"request_id": &schema.Schema{
 				Type:     schema.TypeString,
@@ -36,36 +51,237 @@ func resourceRequest() *schema.Resource {
 			"num_retries_on_failure": &schema.Schema{
 				Type:     schema.TypeInt,
 				Optional: true,
-				ForceNew: true,
 			},
 			"schedule": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				ForceNew: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateSchedule,
 			},
 			"schedule_type": &schema.Schema{
 				Type:         schema.TypeString,
 				Optional:     true,
 				ValidateFunc: validateRequestScheduleType,
-				ForceNew:     true,
+			},
+			"schedule_time_zone": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateTimeZone,
+			},
+			"next_fire_times": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"scheduled_expected_runtime_millis": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validatePositiveInt,
+			},
+			"task_execution_time_limit_millis": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validatePositiveInt,
 			},
 			"instances": &schema.Schema{
 				Type:     schema.TypeInt,
 				Optional: true,
 			},
 			"state": &schema.Schema{
-				Type:         schema.TypeString,
-				Optional:     true,
-				Computed:     true,
-				ValidateFunc: validateRequestState,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				ValidateFunc:     validateRequestState,
+				DiffSuppressFunc: suppressRequestStateDiff,
+			},
+			"scale": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"incremental": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+						},
+						"bounce": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+						},
+						"skip_healthchecks": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+						},
+						"message": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"duration_millis": &schema.Schema{
+							Type:     schema.TypeInt,
+							Optional: true,
+						},
+					},
+				},
+			},
+			"expiring_scale": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"revert_to_instances": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"start_millis": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"duration_millis": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"expiring_pause": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"start_millis": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"duration_millis": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"kill_tasks_on_pause": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"pause_duration_millis": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
 			},
 			"slave_placement": &schema.Schema{
 				Type:         schema.TypeString,
 				Optional:     true,
 				Default:      "SEPARATE_BY_DEPLOY",
-				ForceNew:     true,
 				ValidateFunc: validateRequestSlavePlacement,
 			},
+			"required_slave_attributes": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"allowed_slave_attributes": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"rack_affinity": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"rack_sensitive": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+			"max_tasks_per_offer": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validatePositiveInt,
+			},
+			"hide_even_number_across_racks_hint": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+			"allow_bounce_to_same_host": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+			"owners": &schema.Schema{
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateEmail,
+				},
+				Set: schema.HashString,
+			},
+			"required_role": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"group": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"read_only_groups": &schema.Schema{
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Set:      schema.HashString,
+			},
+			"read_write_groups": &schema.Schema{
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Set:      schema.HashString,
+			},
+			"deletion_protection": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"delete_from_load_balancer": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"destroy_mode": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "delete",
+				ValidateFunc: validateDestroyMode,
+			},
+			"delete_message": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "Terraform detected changes",
+				ValidateFunc: validateDeleteMessage,
+			},
+			"task_priority_level": &schema.Schema{
+				Type:         schema.TypeFloat,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validatePriorityLevel,
+			},
+			"task_log_error_regex": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateRegex,
+			},
+			"task_log_error_regex_case_sensitive": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Computed: true,
+			},
+			"kill_old_non_long_running_tasks_after_millis": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validatePositiveInt,
+			},
+			"wait_at_least_millis_after_task_finishes_for_reschedule": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validatePositiveInt,
+			},
 		},
 	}
 }
@@ -74,138 +290,186 @@ func resourceRequestCreate(d *schema.ResourceData, m interface{}) error {
 	id := d.Get("request_id").(string)
 	d.SetId(id)
 	log.Printf("[INFO] Creating request id: (%s)", id)
-	return createRequest(d, m)
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutCreate))
+	defer cancel()
+	return createRequest(ctx, d, m)
 }
 
-func resourceScaleRequest(d *schema.ResourceData, m interface{}) error {
+func resourceScaleRequest(ctx context.Context, d *schema.ResourceData, m interface{}) error {
 	client := clientConn(m)
 	id := d.Get("request_id").(string)
 	instances := d.Get("instances").(int)
-	message := fmt.Sprintf("scale to %d", instances)
-	// TODO:
-	// Make this configurable
-	increment := 1
-	log.Printf("[INFO] Scale request id: (%s)", id)
-	req := singularity.NewRequestScale(
-		id,
-		message,
-		instances,
-		increment,
-	)
-	resp, err := singularity.ScaleRequest(client, *req)
-	if err != nil {
-		return fmt.Errorf("scale request ID: (%v) error, %v", id, err)
+	req := scaleRequestBody{
+		Instances: instances,
+		Message:   fmt.Sprintf("scale to %d", instances),
+		// An actionId makes the scale safe to retry.
+		ActionID: newActionID(),
 	}
-	if resp.RestyResponse.StatusCode() == 200 {
-		return nil
+	// An empty scale block has no settings, and leaves the defaults.
+	if l := d.Get("scale").([]interface{}); len(l) > 0 && l[0] != nil {
+		scale := l[0].(map[string]interface{})
+		req.Incremental = scale["incremental"].(bool)
+		req.Bounce = scale["bounce"].(bool)
+		req.SkipHealthchecks = scale["skip_healthchecks"].(bool)
+		req.DurationMillis = int64(scale["duration_millis"].(int))
+		if message := scale["message"].(string); message != "" {
+			req.Message = message
+		}
 	}
-	return fmt.Errorf("scale request ID: (%v) error, %v", id, err)
+	log.Printf("[INFO] Scale request id: (%s)", id)
+	_, err := scaleRequest(ctx, client, id, req)
+	return err
 }
 
 func resourceRequestExists(d *schema.ResourceData, m interface{}) (b bool, e error) {
 	// Exists - This is called to verify a resource still exists. It is called prior to Read,
 	// and lowers the burden of Read to be able to assume the resource exists.
-	client := clientConn(m)
-	r, err := client.GetRequestByID(d.Id())
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutRead))
+	defer cancel()
+	r, err := getRequest(ctx, clientConn(m), d.Id())
+	if isGone(r, err) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
-	if r.RestyResponse.StatusCode() == 404 {
-		//return false, fmt.Errorf("%v", r.RestyResponse.Status())
-		return false, nil
-	}
 	return true, nil
 
 }
 
-func createRequest(d *schema.ResourceData, m interface{}) error {
+// createRequest creates the request, giving up once ctx is done.
+func createRequest(ctx context.Context, d *schema.ResourceData, m interface{}) error {
 	id := strings.ToLower(d.Get("request_id").(string))
+
+	// Singularity takes several seconds to clean up a deleted request and
+	// rejects a new one with the same ID meanwhile. Only wait when this run
+	// deleted it, e.g. on update or replacement.
+	if deletedInRun(m, id) {
+		if err := waitForRequestDeletion(ctx, clientConn(m), id); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Singularity request  '%s' is being provisioned...", id)
+	req, err := buildRequest(d)
+	if err != nil {
+		return err
+	}
+	// instances is applied by scaling, so the scale block is honoured.
+	_, scale := d.GetOkExists("instances")
+	scale = scale && checkRequestTypeMatch(req.RequestType, "SERVICE", "WORKER", "ON_DEMAND")
+	if scale {
+		req.Instances = nil
+	}
+	if _, err := saveRequest(ctx, clientConn(m), req); err != nil {
+		return err
+	}
+	if scale {
+		if err := resourceScaleRequest(ctx, d, m); err != nil {
+			return err
+		}
+	}
+	if d.Get("state").(string) == "PAUSED" {
+		if err := pauseOrUnpauseRequest(ctx, d, m); err != nil {
+			return err
+		}
+	}
+	return readRequest(ctx, d, m)
+}
+
+// buildRequest maps the resource data onto a Singularity request. The same
+// payload is used to create a request and, POSTed with an existing ID, to
+// update it in place.
+func buildRequest(d *schema.ResourceData) (singularityRequest, error) {
 	numRetriesOnFailure := int64(d.Get("num_retries_on_failure").(int))
 	cronFormat := d.Get("schedule").(string)
 	scheduleType := strings.ToUpper(d.Get("schedule_type").(string))
-	requestType := strings.ToLower(d.Get("request_type").(string))
-	instances := int64(d.Get("instances").(int))
-	slavePlacement := strings.ToUpper(d.Get("slave_placement").(string))
-
-	// This is a workaround when Singularity delete existing object. Takes several
-	// seconds normally.
-	timeout := 30
-	log.Printf("[TRACE] WAITING for %d seconds", timeout)
-	time.Sleep(time.Duration(timeout) * time.Second)
+	// Unset instances are left out, and Singularity runs one.
+	var instances *int64
+	if v := int64(d.Get("instances").(int)); v != 0 {
+		instances = &v
+	}
 
 	// Singularity expects uppercase of these values and in our validator,
 	// we expect only uppercase to make our resource simpler. Having said
 	// that, it does not hurt to always check for value/s in same lowercase.
-	log.Printf("Singularity request  '%s' is being provisioned...", id)
-	if requestType == "run_once" {
-		resp, err := singularity.NewRequest(singularity.RUN_ONCE, id).
-			SetInstances(instances).
-			SetNumRetriesOnFailures(numRetriesOnFailure).
-			SetSlavePlacement(slavePlacement).
-			Create(clientConn(m))
-		return checkResponse(d, m, resp, err)
-	}
-	if requestType == "scheduled" {
-		req := singularity.NewRequest(singularity.SCHEDULED, "")
-		_, err := req.SetScheduleType(scheduleType)
-		if err != nil {
-			return fmt.Errorf("scheduleType invalid: %v", err)
-		}
-		_, err = req.SetSchedule(cronFormat)
-		if err != nil {
-			return fmt.Errorf("cronFormat invalid: %v", err)
-		}
+	req := singularityRequest{
+		ID:                                    strings.ToLower(d.Get("request_id").(string)),
+		RequestType:                           strings.ToUpper(d.Get("request_type").(string)),
+		SlavePlacement:                        strings.ToUpper(d.Get("slave_placement").(string)),
+		RequiredSlaveAttributes:               tagsToMap(d.Get("required_slave_attributes").(map[string]interface{})),
+		AllowedSlaveAttributes:                tagsToMap(d.Get("allowed_slave_attributes").(map[string]interface{})),
+		RackAffinity:                          expandStringList(d.Get("rack_affinity").([]interface{})),
+		RackSensitive:                         d.Get("rack_sensitive").(bool),
+		MaxTasksPerOffer:                      d.Get("max_tasks_per_offer").(int),
+		HideEvenNumberAcrossRacksHint:         d.Get("hide_even_number_across_racks_hint").(bool),
+		AllowBounceToSameHost:                 d.Get("allow_bounce_to_same_host").(bool),
+		Owners:                                expandStringList(d.Get("owners").(*schema.Set).List()),
+		RequiredRole:                          d.Get("required_role").(string),
+		Group:                                 d.Get("group").(string),
+		ReadOnlyGroups:                        expandStringList(d.Get("read_only_groups").(*schema.Set).List()),
+		ReadWriteGroups:                       expandStringList(d.Get("read_write_groups").(*schema.Set).List()),
+		TaskExecutionTimeLimitMillis:          int64(d.Get("task_execution_time_limit_millis").(int)),
+		TaskLogErrorRegex:                     d.Get("task_log_error_regex").(string),
+		KillOldNonLongRunningTasksAfterMillis: int64(d.Get("kill_old_non_long_running_tasks_after_millis").(int)),
+		WaitAtLeastMillisAfterTaskFinishesForReschedule: int64(d.Get("wait_at_least_millis_after_task_finishes_for_reschedule").(int)),
+	}
+	// Leave these to the scheduler's defaults unless they are configured.
+	if v, ok := d.GetOkExists("task_log_error_regex_case_sensitive"); ok {
+		caseSensitive := v.(bool)
+		req.TaskLogErrorRegexCaseSensitive = &caseSensitive
+	}
+	if v, ok := d.GetOkExists("task_priority_level"); ok {
+		priority := v.(float64)
+		req.TaskPriorityLevel = &priority
+	}
 
-		if instances > 1 {
-			return fmt.Errorf("Scheduled request can only have instance of: %d", 1)
+	// customizeRequestType has already rejected arguments the type does not
+	// take.
+	switch req.RequestType {
+	case "ON_DEMAND":
+		req.Instances = instances
+		req.NumRetriesOnFailure = numRetriesOnFailure
+	case "RUN_ONCE":
+		req.NumRetriesOnFailure = numRetriesOnFailure
+	case "SERVICE", "WORKER":
+		req.Instances = instances
+	case "SCHEDULED":
+		if _, err := parseSchedule(cronFormat, scheduleType); err != nil {
+			return req, fmt.Errorf("schedule invalid: %v", err)
 		}
-		resp, err := req.SetNumRetriesOnFailures(numRetriesOnFailure).
-			SetID(id).
-			SetNumRetriesOnFailures(numRetriesOnFailure).
-			SetSlavePlacement(slavePlacement).
-			Create(clientConn(m))
-
-		if err != nil {
-			return fmt.Errorf("Create new scheduled type request error %v", err)
+		switch scheduleType {
+		case "CRON":
+			req.Schedule = cronFormat
+		case "QUARTZ":
+			req.QuartzSchedule = cronFormat
+		default:
+			return req, fmt.Errorf("scheduleType invalid: must be one of CRON or QUARTZ, got %q", scheduleType)
 		}
-		return checkResponse(d, m, resp, err)
-	}
-	if requestType == "service" {
-		resp, err := singularity.NewRequest(singularity.SERVICE, id).
-			SetInstances(instances).
-			SetSlavePlacement(slavePlacement).
-			Create(clientConn(m))
-		return checkResponse(d, m, resp, err)
-	}
-	if requestType == "on_demand" {
-		resp, err := singularity.NewRequest(singularity.ON_DEMAND, id).
-			SetNumRetriesOnFailures(numRetriesOnFailure).
-			SetInstances(instances).
-			SetSlavePlacement(slavePlacement).
-			Create(clientConn(m))
-		return checkResponse(d, m, resp, err)
-	}
-	if requestType == "worker" {
-		resp, err := singularity.NewRequest(singularity.WORKER, id).
-			SetInstances(instances).
-			SetSlavePlacement(slavePlacement).
-			Create(clientConn(m))
-		return checkResponse(d, m, resp, err)
+		req.ScheduleType = scheduleType
+		req.ScheduleTimeZone = d.Get("schedule_time_zone").(string)
+		req.ScheduledExpectedRuntimeMillis = int64(d.Get("scheduled_expected_runtime_millis").(int))
+		req.NumRetriesOnFailure = numRetriesOnFailure
+	default:
+		return req, fmt.Errorf("unsupported request type: %s", req.RequestType)
 	}
-
-	return nil
+	return req, nil
 }
 
-func checkResponse(d *schema.ResourceData, m interface{}, r singularity.HTTPResponse, err error) error {
-	log.Printf("[TRACE] HTTP Response %v", r.RestyResponse)
-
-	if err != nil {
-		return fmt.Errorf("Create Singularity request error: %v", err)
-	}
-	if r.RestyResponse.StatusCode() <= 200 && r.RestyResponse.StatusCode() >= 299 {
-		return fmt.Errorf("Create Singularity request error %v: %v", r.RestyResponse.StatusCode(), err)
-	}
-	return resourceRequestRead(d, m)
+// waitForRequestDeletion polls request id until it is gone or DELETED.
+func waitForRequestDeletion(ctx context.Context, client *singularity.Client, id string) error {
+	what := fmt.Sprintf("request %s to finish deleting", id)
+	return waitFor(ctx, requestPollInterval, what, func() (bool, error) {
+		r, err := getRequest(ctx, client, id)
+		if isGone(r, err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		log.Printf("[DEBUG] Request %s is %s", id, r.State)
+		return false, nil
+	})
 }
 
 // resourceRequestRead is called to resync the local state with the remote state.
@@ -213,94 +477,510 @@ func checkResponse(d *schema.ResourceData, m interface{}, r singularity.HTTPResp
 // to look up the resource. Any remote data should be updated into the local data.
 // No changes to the remote resource are to be made.
 func resourceRequestRead(d *schema.ResourceData, m interface{}) error {
-	client := clientConn(m)
-	r, err := client.GetRequestByID(d.Id())
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutRead))
+	defer cancel()
+	return readRequest(ctx, d, m)
+}
+
+// readRequest refreshes the request's state, giving up once ctx is done.
+func readRequest(ctx context.Context, d *schema.ResourceData, m interface{}) error {
+	r, err := getRequest(ctx, clientConn(m), d.Id())
+	if isGone(r, err) {
+		log.Printf("[WARN] Request %s no longer exists, removing it from state", d.Id())
+		d.SetId("")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	if r.RestyResponse.StatusCode() == 404 {
-		return fmt.Errorf("%v", string(r.RestyResponse.Body()))
+	d.Set("request_id", r.SingularityRequest.ID)
+	d.Set("request_type", r.SingularityRequest.RequestType)
+	d.Set("slave_placement", r.SingularityRequest.SlavePlacement)
+	d.Set("required_slave_attributes", r.SingularityRequest.RequiredSlaveAttributes)
+	d.Set("allowed_slave_attributes", r.SingularityRequest.AllowedSlaveAttributes)
+	d.Set("rack_affinity", r.SingularityRequest.RackAffinity)
+	d.Set("rack_sensitive", r.SingularityRequest.RackSensitive)
+	d.Set("max_tasks_per_offer", r.SingularityRequest.MaxTasksPerOffer)
+	d.Set("hide_even_number_across_racks_hint", r.SingularityRequest.HideEvenNumberAcrossRacksHint)
+	d.Set("allow_bounce_to_same_host", r.SingularityRequest.AllowBounceToSameHost)
+	d.Set("task_execution_time_limit_millis", r.SingularityRequest.TaskExecutionTimeLimitMillis)
+	d.Set("owners", r.SingularityRequest.Owners)
+	d.Set("required_role", r.SingularityRequest.RequiredRole)
+	d.Set("group", r.SingularityRequest.Group)
+	d.Set("read_only_groups", r.SingularityRequest.ReadOnlyGroups)
+	d.Set("read_write_groups", r.SingularityRequest.ReadWriteGroups)
+	d.Set("task_log_error_regex", r.SingularityRequest.TaskLogErrorRegex)
+	if r.SingularityRequest.TaskPriorityLevel != nil {
+		d.Set("task_priority_level", *r.SingularityRequest.TaskPriorityLevel)
+	}
+	if r.SingularityRequest.TaskLogErrorRegexCaseSensitive != nil {
+		d.Set("task_log_error_regex_case_sensitive", *r.SingularityRequest.TaskLogErrorRegexCaseSensitive)
+	}
+	d.Set("kill_old_non_long_running_tasks_after_millis", r.SingularityRequest.KillOldNonLongRunningTasksAfterMillis)
+	d.Set("wait_at_least_millis_after_task_finishes_for_reschedule",
+		r.SingularityRequest.WaitAtLeastMillisAfterTaskFinishesForReschedule)
+	// An expiring pause ends on its own. Once it has run its course, keep
+	// it, so suppressRequestStateDiff knows not to pause the request again.
+	// A request unpaused early drops it.
+	pause := flattenExpiringPause(r.ExpiringPause)
+	if prior := d.Get("expiring_pause").([]interface{}); r.ExpiringPause == nil && r.State == "ACTIVE" &&
+		pauseEnded(prior, time.Now()) {
+		pause = prior
+	}
+	d.Set("state", r.State)
+	if err := d.Set("expiring_pause", pause); err != nil {
+		return fmt.Errorf("flatten expiring_pause error: %v", err)
 	}
-	d.Set("request_id", r.Body.SingularityRequest.ID)
-	d.Set("request_type", r.Body.SingularityRequest.RequestType)
-	d.Set("slave_placement", r.Body.SingularityRequest.SlavePlacement)
 
-	// Only these three types of request expects instance number set.
-	if checkRequestTypeMatch(r.Body, "ON_DEMAND", "WORKER", "SERVICE") {
-		d.Set("instances", r.Body.SingularityRequest.Instances)
+	// Only these three types of request expects instance number set. An
+	// expiring scale reverts on its own. Once it has reverted, keep the
+	// instances it applied, and what it reverted to, rather than planning
+	// the same scale again. Any other count shows up as a change.
+	expiringScale := flattenExpiringScale(r.ExpiringScale)
+	if checkRequestTypeMatch(r.SingularityRequest.RequestType, "ON_DEMAND", "WORKER", "SERVICE") {
+		prior := d.Get("expiring_scale").([]interface{})
+		if r.ExpiringScale == nil && scaleRevertedTo(prior, r.SingularityRequest.instances()) {
+			expiringScale = prior
+		} else {
+			d.Set("instances", r.SingularityRequest.instances())
+		}
+	}
+	if err := d.Set("expiring_scale", expiringScale); err != nil {
+		return fmt.Errorf("flatten expiring_scale error: %v", err)
 	}
 	// Only a scheuled type service expect below parameters.
-	if checkRequestTypeMatch(r.Body, "SCHEDULED") {
-		d.Set("schedule", r.Body.SingularityRequest.Schedule)
-		d.Set("schedule_type", r.Body.SingularityRequest.ScheduleType)
+	if checkRequestTypeMatch(r.SingularityRequest.RequestType, "SCHEDULED") {
+		schedule := r.SingularityRequest.Schedule
+		if strings.ToUpper(r.SingularityRequest.ScheduleType) == "QUARTZ" && r.SingularityRequest.QuartzSchedule != "" {
+			schedule = r.SingularityRequest.QuartzSchedule
+		}
+		d.Set("schedule", schedule)
+		d.Set("schedule_type", r.SingularityRequest.ScheduleType)
+		d.Set("schedule_time_zone", r.SingularityRequest.ScheduleTimeZone)
+		d.Set("scheduled_expected_runtime_millis", r.SingularityRequest.ScheduledExpectedRuntimeMillis)
+
+		times, err := scheduleNextFireTimes(schedule, r.SingularityRequest.ScheduleType,
+			r.SingularityRequest.ScheduleTimeZone, time.Now())
+		if err != nil {
+			log.Printf("[WARN] Unable to work out next fire times of request %s: %v", d.Id(), err)
+		}
+		d.Set("next_fire_times", times)
 	}
 
 	// Only a service or run_once or on_demand type expect below parameters.
-	if checkRequestTypeMatch(r.Body, "SCHEDULED", "RUN_ONCE", "ON_DEMAND") {
-		d.Set("num_retries_on_failure", r.Body.SingularityRequest.NumRetriesOnFailure)
+	if checkRequestTypeMatch(r.SingularityRequest.RequestType, "SCHEDULED", "RUN_ONCE", "ON_DEMAND") {
+		d.Set("num_retries_on_failure", r.SingularityRequest.NumRetriesOnFailure)
 	}
 	return nil
 }
 
+func expandStringList(in []interface{}) []string {
+	var out []string
+	for _, v := range in {
+		out = append(out, v.(string))
+	}
+	return out
+}
+
+func flattenExpiringPause(in *expiringPause) []interface{} {
+	if in == nil || in.DurationMillis == 0 {
+		return []interface{}{}
+	}
+	m := make(map[string]interface{})
+	m["start_millis"] = in.StartMillis
+	m["duration_millis"] = in.DurationMillis
+	return []interface{}{m}
+}
+
+// pauseEnded reports whether the expiring pause recorded in expiring_pause
+// has run its course by now.
+func pauseEnded(expiringPause []interface{}, now time.Time) bool {
+	if len(expiringPause) == 0 || expiringPause[0] == nil {
+		return false
+	}
+	p := expiringPause[0].(map[string]interface{})
+	end := int64(p["start_millis"].(int)) + int64(p["duration_millis"].(int))
+	return now.UnixNano()/int64(time.Millisecond) >= end
+}
+
+// scaleRevertedTo reports whether the expiring scale recorded in
+// expiring_scale reverts to instances.
+func scaleRevertedTo(expiringScale []interface{}, instances int64) bool {
+	if len(expiringScale) == 0 || expiringScale[0] == nil {
+		return false
+	}
+	return int64(expiringScale[0].(map[string]interface{})["revert_to_instances"].(int)) == instances
+}
+
+func flattenExpiringScale(in *singularity.SingularityExpiringScale) []interface{} {
+	if in == nil {
+		return []interface{}{}
+	}
+	m := make(map[string]interface{})
+	m["revert_to_instances"] = in.RevertToInstances
+	m["start_millis"] = in.StartMillis
+	m["duration_millis"] = in.DurationMillis
+	return []interface{}{m}
+}
+
 func resourceRequestUpdate(d *schema.ResourceData, m interface{}) error {
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutUpdate))
+	defer cancel()
 
-	if d.HasChange("request_id") ||
-		d.HasChange("schedule") ||
-		d.HasChange("request_type") ||
+	// request_id and request_type force a new resource. Everything else is
+	// updated by POSTing the request again under its existing ID, which
+	// leaves the active deploy and its tasks running.
+	if d.HasChange("schedule") ||
 		d.HasChange("num_retries_on_failure") ||
 		d.HasChange("schedule_type") ||
-		d.HasChange("slave_placement") {
-		log.Printf("[TRACE] Delete and update existing request id (%s) success", d.Id())
-		// TODO: Investigate whether we can just update existing request, rather
-		// than delete and add.
-		// Delete existing request and add if there are changes. I couldn't manage
-		// to find API doco to update existing request. Only for existing deploy.
-		err := resourceRequestDelete(d, m)
-		if err != nil {
+		d.HasChange("schedule_time_zone") ||
+		d.HasChange("scheduled_expected_runtime_millis") ||
+		d.HasChange("task_execution_time_limit_millis") ||
+		d.HasChange("slave_placement") ||
+		d.HasChange("required_slave_attributes") ||
+		d.HasChange("allowed_slave_attributes") ||
+		d.HasChange("rack_affinity") ||
+		d.HasChange("rack_sensitive") ||
+		d.HasChange("max_tasks_per_offer") ||
+		d.HasChange("hide_even_number_across_racks_hint") ||
+		d.HasChange("allow_bounce_to_same_host") ||
+		d.HasChange("owners") ||
+		d.HasChange("required_role") ||
+		d.HasChange("group") ||
+		d.HasChange("read_only_groups") ||
+		d.HasChange("read_write_groups") ||
+		d.HasChange("task_priority_level") ||
+		d.HasChange("task_log_error_regex") ||
+		d.HasChange("task_log_error_regex_case_sensitive") ||
+		d.HasChange("kill_old_non_long_running_tasks_after_millis") ||
+		d.HasChange("wait_at_least_millis_after_task_finishes_for_reschedule") {
+		log.Printf("[INFO] Updating request id: (%s)", d.Id())
+		if err := updateRequest(ctx, d, m); err != nil {
 			return err
 		}
-		return resourceRequestCreate(d, m)
 	}
 	if d.HasChange("instances") {
-		return resourceScaleRequest(d, m)
+		if err := resourceScaleRequest(ctx, d, m); err != nil {
+			return err
+		}
+	}
+	if d.HasChange("state") {
+		if err := pauseOrUnpauseRequest(ctx, d, m); err != nil {
+			return err
+		}
+	}
+	return readRequest(ctx, d, m)
+}
+
+// updateRequest updates an existing request in place. Its instances are
+// left as they are, as changing them is up to resourceScaleRequest.
+func updateRequest(ctx context.Context, d *schema.ResourceData, m interface{}) error {
+	req, err := buildRequest(d)
+	if err != nil {
+		return err
+	}
+	if checkRequestTypeMatch(req.RequestType, "SERVICE", "WORKER", "ON_DEMAND") {
+		r, err := getRequest(ctx, clientConn(m), d.Id())
+		if err != nil {
+			return err
+		}
+		req.Instances = r.SingularityRequest.Instances
+	}
+	_, err = saveRequest(ctx, clientConn(m), req)
+	return err
+}
+
+// pauseOrUnpauseRequest moves the request to the configured state.
+func pauseOrUnpauseRequest(ctx context.Context, d *schema.ResourceData, m interface{}) error {
+	client := clientConn(m)
+	id := strings.ToLower(d.Get("request_id").(string))
+	if d.Get("state").(string) == "PAUSED" {
+		log.Printf("[INFO] Pausing request id: (%s)", id)
+		_, err := pauseRequest(ctx, client, id, pauseRequestBody{
+			KillTasks:      d.Get("kill_tasks_on_pause").(bool),
+			DurationMillis: int64(d.Get("pause_duration_millis").(int)),
+			ActionID:       newActionID(),
+		})
+		return err
+	}
+	if pauseEnded(d.Get("expiring_pause").([]interface{}), time.Now()) {
+		log.Printf("[INFO] Request id: (%s) is already unpaused, its pause has ended", id)
+		return nil
+	}
+	log.Printf("[INFO] Unpausing request id: (%s)", id)
+	_, err := unpauseRequest(ctx, client, id, unpauseRequestBody{
+		ActionID: newActionID(),
+	})
+	return err
+}
+
+// suppressRequestStateDiff treats the transient states Singularity reports
+// while a request is coming back up as ACTIVE. A request whose expiring
+// pause has run its course is not paused again, unless pause_duration_millis
+// changes.
+func suppressRequestStateDiff(k, old, new string, d *schema.ResourceData) bool {
+	switch new {
+	case "ACTIVE":
+		return old == "SYSTEM_COOLDOWN" || old == "DEPLOYING_TO_UNPAUSE"
+	case "PAUSED":
+		return old == "ACTIVE" && !d.HasChange("pause_duration_millis") &&
+			pauseEnded(d.Get("expiring_pause").([]interface{}), time.Now())
+	}
+	return false
+}
+
+// deleteMessageData is what a delete_message template can refer to.
+type deleteMessageData struct {
+	RequestID   string
+	RequestType string
+	DestroyMode string
+}
+
+// renderDeleteMessage renders a delete_message template.
+func renderDeleteMessage(text string, data deleteMessageData) (string, error) {
+	tmpl, err := template.New("delete_message").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// customizeRequestDeletionProtection stops a protected request from being
+// replaced. A plain destroy is stopped in resourceRequestDelete, as destroy
+// plans skip CustomizeDiff. A request that destroy_mode keeps cannot change
+// type either, as the replacement would be saved over it and Singularity
+// does not let a request change type.
+func customizeRequestDeletionProtection(d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" || !(d.HasChange("request_id") || d.HasChange("request_type")) {
+		return nil
+	}
+	if protected, _ := d.GetChange("deletion_protection"); protected.(bool) {
+		return fmt.Errorf("request %q has deletion_protection set and changing request_id or request_type "+
+			"would replace it; set deletion_protection to false and apply first", d.Id())
+	}
+	// The destroy half of a replacement uses the mode in state.
+	mode, _ := d.GetChange("destroy_mode")
+	if d.HasChange("request_type") && (mode == "pause" || mode == "abandon") {
+		return fmt.Errorf("request %q has destroy_mode %q, which keeps it in Singularity, so its "+
+			"request_type cannot change; set destroy_mode to \"delete\" and apply first", d.Id(), mode)
 	}
 	return nil
 }
 
 func resourceRequestDelete(d *schema.ResourceData, m interface{}) error {
-	a := deleteRequest(d.Id())
+	ctx, cancel := operationContext(m, d.Timeout(schema.TimeoutDelete))
+	defer cancel()
+	id := d.Id()
+	if d.Get("deletion_protection").(bool) {
+		return fmt.Errorf("request %q has deletion_protection set; set it to false and apply before destroying it", id)
+	}
+
+	mode := d.Get("destroy_mode").(string)
+	message, err := renderDeleteMessage(d.Get("delete_message").(string), deleteMessageData{
+		RequestID:   id,
+		RequestType: d.Get("request_type").(string),
+		DestroyMode: mode,
+	})
+	if err != nil {
+		return fmt.Errorf("delete_message error: %v", err)
+	}
+
+	switch mode {
+	case "abandon":
+		log.Printf("[INFO] Abandoning request id: (%s), it is left in Singularity", id)
+		d.SetId("")
+		return nil
+	case "pause":
+		if d.Get("state").(string) != "PAUSED" {
+			log.Printf("[INFO] Pausing request id: (%s) instead of deleting it", id)
+			_, err := pauseRequest(ctx, clientConn(m), id, pauseRequestBody{
+				KillTasks: d.Get("kill_tasks_on_pause").(bool),
+				ActionID:  newActionID(),
+				Message:   message,
+			})
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		d.SetId("")
+		return nil
+	case "scale_to_zero_then_delete":
+		if err := scaleToZero(ctx, d, m, message); err != nil {
+			return err
+		}
+	}
+
+	a := deleteRequest(ctx, id, singularity.SingularityDeleteRequest{
+		DeleteFromLoadBalancer: d.Get("delete_from_load_balancer").(bool),
+		Message:                message,
+		ActionID:               newActionID(),
+	})
 	return a(d, m)
 }
 
-func deleteRequest(id string) (f func(d *schema.ResourceData, m interface{}) error) {
-	return func(d *schema.ResourceData, m interface{}) error {
-		req := singularity.NewDeleteRequest(id,
-			"Terraform detected changes",
-			"Terraform update",
-			false)
-		resp, err := singularity.DeleteRequest(clientConn(m), req)
-		if err != nil {
-			return err
+// scaleToZero scales the request down and waits for its tasks to stop, so
+// they finish cleanly rather than being killed by the delete. Only request
+// types with instances can be scaled, others are deleted straight away.
+func scaleToZero(ctx context.Context, d *schema.ResourceData, m interface{}, message string) error {
+	id := d.Id()
+	if !checkRequestTypeMatch(d.Get("request_type").(string), "SERVICE", "WORKER", "ON_DEMAND") {
+		log.Printf("[INFO] Request id: (%s) cannot be scaled, deleting it", id)
+		return nil
+	}
+	client := clientConn(m)
+	log.Printf("[INFO] Scaling request id: (%s) to zero before deleting it", id)
+	_, err := scaleRequest(ctx, client, id, scaleRequestBody{
+		Instances: 0,
+		ActionID:  newActionID(),
+		Message:   message,
+	})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	what := fmt.Sprintf("tasks of request %s to stop", id)
+	return waitFor(ctx, requestPollInterval, what, func() (bool, error) {
+		tasks, err := listActiveTasks(ctx, client, id)
+		if isNotFound(err) {
+			return true, nil
 		}
-		if resp.RestyResponse.StatusCode() == 404 {
+		return len(tasks) == 0, err
+	})
+}
+
+// deleteRequest returns a function deleting request id with req, which
+// treats a request that is already gone as deleted.
+func deleteRequest(ctx context.Context, id string, req singularity.SingularityDeleteRequest) (f func(d *schema.ResourceData, m interface{}) error) {
+	return func(d *schema.ResourceData, m interface{}) error {
+		err := removeRequest(ctx, clientConn(m), id, req)
+		if isNotFound(err) {
 			// This could have been deleted manually.
 			return nil
 		}
+		if err != nil {
+			return err
+		}
+		recordDeletion(m, id)
 		d.SetId("")
 		return nil
 	}
 }
 
+// requestTypeFields lists the arguments that only some request types take,
+// along with those types.
+var requestTypeFields = []struct {
+	key   string
+	types []string
+}{
+	{"instances", []string{"SERVICE", "WORKER", "ON_DEMAND"}},
+	{"scale", []string{"SERVICE", "WORKER", "ON_DEMAND"}},
+	{"num_retries_on_failure", []string{"SCHEDULED", "RUN_ONCE", "ON_DEMAND"}},
+	{"schedule", []string{"SCHEDULED"}},
+	{"schedule_type", []string{"SCHEDULED"}},
+	{"schedule_time_zone", []string{"SCHEDULED"}},
+	{"scheduled_expected_runtime_millis", []string{"SCHEDULED"}},
+}
+
+// customizeRequestType rejects arguments that request_type does not take,
+// rather than dropping them when the request is built.
+func customizeRequestType(d *schema.ResourceDiff, m interface{}) error {
+	if !d.NewValueKnown("request_type") {
+		return nil
+	}
+	requestType := strings.ToUpper(d.Get("request_type").(string))
+
+	var problems []string
+	for _, f := range requestTypeFields {
+		if _, ok := d.GetOk(f.key); !ok && d.NewValueKnown(f.key) {
+			continue
+		}
+		// Scheduled requests always run a single instance, and saying so
+		// is harmless.
+		if f.key == "instances" && requestType == "SCHEDULED" && d.Get("instances").(int) == 1 {
+			continue
+		}
+		if !checkRequestTypeMatch(requestType, f.types...) {
+			problems = append(problems, fmt.Sprintf("%q is only supported by %s requests",
+				f.key, strings.Join(f.types, ", ")))
+		}
+	}
+	if _, ok := d.GetOk("scale"); ok && d.NewValueKnown("instances") {
+		if _, ok := d.GetOkExists("instances"); !ok {
+			problems = append(problems, `"scale" requires "instances"`)
+		}
+	}
+	if requestType == "SCHEDULED" {
+		for _, key := range []string{"schedule", "schedule_type"} {
+			if _, ok := d.GetOk(key); !ok && d.NewValueKnown(key) {
+				problems = append(problems, fmt.Sprintf("%q is required for SCHEDULED requests", key))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid arguments for %s request %q: %s",
+			requestType, d.Get("request_id").(string), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// customizeRequestSchedule checks schedule against schedule_type, which
+// validateSchedule cannot see, and plans next_fire_times whenever the
+// schedule changes.
+func customizeRequestSchedule(d *schema.ResourceDiff, m interface{}) error {
+	if !checkRequestTypeMatch(d.Get("request_type").(string), "SCHEDULED") {
+		return nil
+	}
+	changed := d.Id() == "" ||
+		d.HasChange("schedule") ||
+		d.HasChange("schedule_type") ||
+		d.HasChange("schedule_time_zone")
+	if !d.NewValueKnown("schedule") ||
+		!d.NewValueKnown("schedule_type") ||
+		!d.NewValueKnown("schedule_time_zone") {
+		if changed {
+			return d.SetNewComputed("next_fire_times")
+		}
+		return nil
+	}
+
+	expr := d.Get("schedule").(string)
+	scheduleType := d.Get("schedule_type").(string)
+	if expr == "" || scheduleType == "" {
+		return nil
+	}
+	times, err := scheduleNextFireTimes(expr, scheduleType, d.Get("schedule_time_zone").(string), time.Now())
+	if err != nil {
+		return fmt.Errorf("schedule %q is not a valid %s expression: %v", expr, scheduleType, err)
+	}
+	if !changed {
+		return nil
+	}
+	return d.SetNew("next_fire_times", times)
+}
+
 func resourceResourceRequestImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	// These only change what Terraform does on destroy, so Singularity
+	// cannot tell us their values.
+	d.Set("deletion_protection", false)
+	d.Set("delete_from_load_balancer", false)
+	d.Set("destroy_mode", "delete")
+	d.Set("delete_message", "Terraform detected changes")
 	if err := resourceRequestRead(d, meta); err != nil {
 		return nil, err
 	}
 	return []*schema.ResourceData{d}, nil
 }
 
-func checkRequestTypeMatch(r singularity.Request, services ...string) bool {
+func checkRequestTypeMatch(requestType string, services ...string) bool {
 	for _, service := range services {
-		if strings.ToUpper(r.RequestType) == strings.ToUpper(service) {
+		if strings.ToUpper(requestType) == strings.ToUpper(service) {
 			return true
 		}
 	}
@@ -1,10 +1,18 @@
 package mesos_singularity
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/terraform/config"
 	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 	singularity "github.com/lenfree/go-singularity"
 )
@@ -47,7 +55,7 @@ func TestAccSingularityRequestRunOnceCreate(t *testing.T) {
 					resource.TestCheckResourceAttr(
 						"singularity_request.foo-run", "request_type", "RUN_ONCE"),
 					resource.TestCheckResourceAttr(
-						"singularity_request.foo-run", "instances", "5"),
+						"singularity_request.foo-run", "num_retries_on_failure", "2"),
 				),
 			},
 		},
@@ -150,7 +158,7 @@ const testAccCheckSingularityRequestRunOnceConfig = `
 resource "singularity_request" "foo-run" {
 			request_id             = "foo-run-id"
 			request_type           = "RUN_ONCE"
-			instances              = 5
+			num_retries_on_failure = 2
 }
 `
 
@@ -282,7 +290,7 @@ func TestCheckRequestTypeMatch(t *testing.T) {
 	}
 
 	for _, tt := range data {
-		val := checkRequestTypeMatch(tt.resp, tt.requestA, tt.requestB, tt.requestC)
+		val := checkRequestTypeMatch(tt.resp.RequestType, tt.requestA, tt.requestB, tt.requestC)
 		if val != tt.expectedBool {
 			t.Errorf("checkRequestTypeMatch(%v, %v, %v, %v): expected %v, got %v",
 				tt.resp.RequestType,
@@ -310,7 +318,7 @@ func TestCheckRequestTypeMatch(t *testing.T) {
 	}
 
 	for _, tt := range data2 {
-		val := checkRequestTypeMatch(tt.resp, tt.requestA)
+		val := checkRequestTypeMatch(tt.resp.RequestType, tt.requestA)
 		if val != tt.expectedBool {
 			t.Errorf("checkRequestTypeMatch(%v, %v): expected %v, got %v",
 				tt.resp.RequestType,
@@ -320,3 +328,876 @@ func TestCheckRequestTypeMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestWaitForRequestDeletion(t *testing.T) {
+	defer testShortPolls()()
+	states := []string{"DELETING", "DELETING", ""}
+	calls := 0
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		state := states[calls]
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if state == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+			return
+		}
+		fmt.Fprintf(w, `{"state": %q}`, state)
+	})
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := waitForRequestDeletion(ctx, conn.sclient, "foo"); err != nil {
+		t.Errorf("waitForRequestDeletion: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("waitForRequestDeletion: expected 3 polls, got %d", calls)
+	}
+}
+
+func TestRecordDeletion(t *testing.T) {
+	conn := &Conn{}
+	if deletedInRun(conn, "foo") {
+		t.Errorf("deletedInRun: expected false before any deletion")
+	}
+	recordDeletion(conn, "Foo")
+	if !deletedInRun(conn, "foo") {
+		t.Errorf("deletedInRun: expected true after deletion")
+	}
+}
+
+func TestResourceRequestUpdateInPlace(t *testing.T) {
+	var methods []string
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"request": {"id": "foo", "requestType": "SCHEDULED", "schedule": "0 8 * * *", "scheduleType": "CRON"}}`))
+	})
+	defer ts.Close()
+
+	d := schema.TestResourceDataRaw(t, resourceRequest().Schema, map[string]interface{}{
+		"request_id":    "foo",
+		"request_type":  "SCHEDULED",
+		"schedule":      "0 8 * * *",
+		"schedule_type": "CRON",
+	})
+	d.SetId("foo")
+	if err := resourceRequestUpdate(d, conn); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	expect := []string{"POST /api/requests", "GET /api/requests/request/foo"}
+	if fmt.Sprint(methods) != fmt.Sprint(expect) {
+		t.Errorf("Update: expected calls %v, got %v", expect, methods)
+	}
+	if d.Id() != "foo" {
+		t.Errorf("Update: expected ID foo to be kept, got %q", d.Id())
+	}
+}
+
+func TestResourceRequestReadGone(t *testing.T) {
+	var data = []struct {
+		status    int
+		body      string
+		expectID  string
+		expectErr bool
+	}{
+		{http.StatusNotFound, `{"message": "Couldn't find request foo"}`, "", false},
+		{http.StatusOK, `{"request": {"id": "foo"}, "state": "DELETED"}`, "", false},
+		{http.StatusOK, `{"request": {"id": "foo", "requestType": "SERVICE"}, "state": "ACTIVE"}`, "foo", false},
+		{http.StatusBadRequest, `{"message": "Bad request"}`, "foo", true},
+	}
+
+	for _, tt := range data {
+		conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		})
+		d := resourceRequest().TestResourceData()
+		d.SetId("foo")
+		err := resourceRequestRead(d, conn)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("Read(%d %s): expected error %v, got %v", tt.status, tt.body, tt.expectErr, err)
+		}
+		if d.Id() != tt.expectID {
+			t.Errorf("Read(%d %s): expected ID %q, got %q", tt.status, tt.body, tt.expectID, d.Id())
+		}
+		ts.Close()
+	}
+}
+
+func TestPauseOrUnpauseRequest(t *testing.T) {
+	var data = []struct {
+		state  string
+		expect string
+		body   string
+	}{
+		{"PAUSED", "/api/requests/request/foo/pause", `{"killTasks":false,"durationMillis":60000,`},
+		{"ACTIVE", "/api/requests/request/foo/unpause", `{"actionId":"terraform-`},
+	}
+
+	for _, tt := range data {
+		var path, body string
+		conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		})
+		d := resourceRequest().TestResourceData()
+		d.Set("request_id", "foo")
+		d.Set("state", tt.state)
+		d.Set("kill_tasks_on_pause", false)
+		d.Set("pause_duration_millis", 60000)
+		if err := pauseOrUnpauseRequest(context.Background(), d, conn); err != nil {
+			t.Errorf("pauseOrUnpauseRequest(%s): %v", tt.state, err)
+		}
+		if path != tt.expect {
+			t.Errorf("pauseOrUnpauseRequest(%s): expected path %s, got %s", tt.state, tt.expect, path)
+		}
+		if !strings.HasPrefix(body, tt.body) {
+			t.Errorf("pauseOrUnpauseRequest(%s): expected body to contain %s, got %s", tt.state, tt.body, body)
+		}
+		ts.Close()
+	}
+}
+
+func TestResourceRequestReadExpiringPause(t *testing.T) {
+	remote := `{"request": {"id": "foo", "requestType": "WORKER"}, "state": "PAUSED",
+		"expiringPause": {"startMillis": 1500000000000, "durationMillis": 60000}}`
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected call %s %s", r.Method, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(remote))
+	})
+	defer ts.Close()
+	d := resourceRequest().TestResourceData()
+	d.SetId("foo")
+	d.Set("state", "PAUSED")
+	d.Set("pause_duration_millis", 60000)
+	if err := resourceRequestRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if v := d.Get("expiring_pause.0.duration_millis").(int); v != 60000 {
+		t.Errorf("Read: expected expiring_pause.0.duration_millis 60000, got %d", v)
+	}
+
+	// The pause has ended. The request shows as ACTIVE, and the ended pause
+	// is kept so it is not paused again.
+	remote = `{"request": {"id": "foo", "requestType": "WORKER"}, "state": "ACTIVE"}`
+	if err := resourceRequestRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if v := d.Get("state"); v != "ACTIVE" {
+		t.Errorf("Read: expected an ended pause to show state ACTIVE, got %v", v)
+	}
+	if v := d.Get("expiring_pause.0.start_millis").(int); v != 1500000000000 {
+		t.Errorf("Read: expected the ended pause to be kept, got start_millis %d", v)
+	}
+	for _, tt := range []struct {
+		duration int
+		expect   bool
+	}{
+		{60000, false},
+		// A new pause_duration_millis pauses it again.
+		{120000, true},
+	} {
+		c, err := config.NewRawConfig(map[string]interface{}{
+			"request_id":            "foo",
+			"request_type":          "WORKER",
+			"state":                 "PAUSED",
+			"pause_duration_millis": tt.duration,
+		})
+		if err != nil {
+			t.Fatalf("config error: %v", err)
+		}
+		diff, err := resourceRequest().Diff(d.State(), terraform.NewResourceConfig(c), conn)
+		if err != nil {
+			t.Fatalf("Diff: %v", err)
+		}
+		if _, ok := diff.Attributes["state"]; ok != tt.expect {
+			t.Errorf("Diff(pause_duration_millis = %d): expected a state change %v, got %v", tt.duration, tt.expect, diff)
+		}
+	}
+	// Setting state back to ACTIVE does not unpause it again.
+	d.Set("state", "ACTIVE")
+	if err := pauseOrUnpauseRequest(context.Background(), d, conn); err != nil {
+		t.Errorf("pauseOrUnpauseRequest: %v", err)
+	}
+
+	// A pause lifted early shows up.
+	d.Set("state", "PAUSED")
+	d.Set("expiring_pause", []interface{}{map[string]interface{}{
+		"start_millis":    int(time.Now().UnixNano() / int64(time.Millisecond)),
+		"duration_millis": 3600000,
+	}})
+	if err := resourceRequestRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if v := d.Get("state"); v != "ACTIVE" {
+		t.Errorf("Read: expected a pause lifted early to show state ACTIVE, got %v", v)
+	}
+}
+
+func TestSuppressRequestStateDiff(t *testing.T) {
+	var data = []struct {
+		old    string
+		new    string
+		expect bool
+	}{
+		{"SYSTEM_COOLDOWN", "ACTIVE", true},
+		{"DEPLOYING_TO_UNPAUSE", "ACTIVE", true},
+		{"PAUSED", "ACTIVE", false},
+		{"SYSTEM_COOLDOWN", "PAUSED", false},
+		{"ACTIVE", "ACTIVE", false},
+	}
+
+	for _, tt := range data {
+		actual := suppressRequestStateDiff("state", tt.old, tt.new, nil)
+		if actual != tt.expect {
+			t.Errorf("suppressRequestStateDiff(%s, %s): expected %v, got %v", tt.old, tt.new, tt.expect, actual)
+		}
+	}
+}
+
+func TestResourceScaleRequest(t *testing.T) {
+	var body string
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	})
+	defer ts.Close()
+
+	d := schema.TestResourceDataRaw(t, resourceRequest().Schema, map[string]interface{}{
+		"request_id":   "foo",
+		"request_type": "WORKER",
+		"instances":    20,
+		"scale": []interface{}{
+			map[string]interface{}{
+				"incremental":     true,
+				"bounce":          true,
+				"message":         "nightly batch",
+				"duration_millis": 3600000,
+			},
+		},
+	})
+	if err := resourceScaleRequest(context.Background(), d, conn); err != nil {
+		t.Fatalf("resourceScaleRequest: %v", err)
+	}
+	expect := `{"instances":20,"incremental":true,"bounce":true,"durationMillis":3600000,"actionId":"terraform-`
+	if !strings.HasPrefix(body, expect) || !strings.HasSuffix(body, `"message":"nightly batch"}`) {
+		t.Errorf("resourceScaleRequest: unexpected body %s", body)
+	}
+
+	// Every scale setting is optional, so an empty block is valid.
+	d = schema.TestResourceDataRaw(t, resourceRequest().Schema, map[string]interface{}{
+		"request_id":   "foo",
+		"request_type": "WORKER",
+		"instances":    20,
+		"scale":        []interface{}{map[string]interface{}{}},
+	})
+	if err := resourceScaleRequest(context.Background(), d, conn); err != nil {
+		t.Fatalf("resourceScaleRequest with an empty scale block: %v", err)
+	}
+	if !strings.HasSuffix(body, `"message":"scale to 20"}`) {
+		t.Errorf("resourceScaleRequest with an empty scale block: unexpected body %s", body)
+	}
+}
+
+func TestResourceRequestScaleOnSave(t *testing.T) {
+	var calls []string
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		call := r.Method + " " + r.URL.Path
+		if r.Method != "GET" {
+			var body map[string]interface{}
+			json.Unmarshal(b, &body)
+			call += fmt.Sprintf(" %v", body["instances"])
+		}
+		calls = append(calls, call)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"request": {"id": "foo", "requestType": "WORKER", "instances": 3}, "state": "ACTIVE"}`))
+	})
+	defer ts.Close()
+
+	d := schema.TestResourceDataRaw(t, resourceRequest().Schema, map[string]interface{}{
+		"request_id":   "foo",
+		"request_type": "WORKER",
+		"instances":    20,
+		"scale": []interface{}{
+			map[string]interface{}{"duration_millis": 3600000},
+		},
+	})
+	if err := resourceRequestCreate(d, conn); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	expect := []string{
+		"POST /api/requests <nil>",
+		"PUT /api/requests/request/foo/scale 20",
+		"GET /api/requests/request/foo",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expect) {
+		t.Errorf("Create: expected calls %v, got %v", expect, calls)
+	}
+
+	// An update keeps the remote instances and scales separately.
+	calls = nil
+	state := &terraform.InstanceState{
+		ID: "foo",
+		Attributes: map[string]string{
+			"id":           "foo",
+			"request_id":   "foo",
+			"request_type": "WORKER",
+			"instances":    "3",
+		},
+	}
+	c, err := config.NewRawConfig(map[string]interface{}{
+		"request_id":   "foo",
+		"request_type": "WORKER",
+		"instances":    20,
+		"owners":       []interface{}{"ops@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("config error: %v", err)
+	}
+	diff, err := resourceRequest().Diff(state, terraform.NewResourceConfig(c), conn)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if _, err := resourceRequest().Apply(state, diff, conn); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	expect = []string{
+		"GET /api/requests/request/foo",
+		"POST /api/requests 3",
+		"PUT /api/requests/request/foo/scale 20",
+		"GET /api/requests/request/foo",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expect) {
+		t.Errorf("Update: expected calls %v, got %v", expect, calls)
+	}
+}
+
+func TestResourceRequestUpdateKeepsZeroInstances(t *testing.T) {
+	var posted map[string]interface{}
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posted = nil
+			json.NewDecoder(r.Body).Decode(&posted)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"request": {"id": "foo", "requestType": "WORKER", "instances": 0}, "state": "ACTIVE"}`))
+	})
+	defer ts.Close()
+
+	// The request was scaled to zero, and an unrelated field changes.
+	state := &terraform.InstanceState{
+		ID: "foo",
+		Attributes: map[string]string{
+			"id":           "foo",
+			"request_id":   "foo",
+			"request_type": "WORKER",
+			"instances":    "0",
+		},
+	}
+	c, err := config.NewRawConfig(map[string]interface{}{
+		"request_id":   "foo",
+		"request_type": "WORKER",
+		"instances":    0,
+		"owners":       []interface{}{"ops@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("config error: %v", err)
+	}
+	diff, err := resourceRequest().Diff(state, terraform.NewResourceConfig(c), conn)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if _, err := resourceRequest().Apply(state, diff, conn); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if v, ok := posted["instances"]; !ok || v != 0.0 {
+		t.Errorf("Update: expected instances 0 to be sent, got %v in %v", v, posted)
+	}
+}
+
+func TestResourceRequestReadExpiringScale(t *testing.T) {
+	var body string
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+	defer ts.Close()
+
+	d := schema.TestResourceDataRaw(t, resourceRequest().Schema, map[string]interface{}{
+		"request_id":   "foo",
+		"request_type": "WORKER",
+		"instances":    20,
+		"scale": []interface{}{
+			map[string]interface{}{"duration_millis": 3600000},
+		},
+	})
+	d.SetId("foo")
+
+	var data = []struct {
+		body      string
+		instances int
+		revertTo  int
+	}{
+		// The scale is in effect.
+		{`{"request": {"id": "foo", "requestType": "WORKER", "instances": 20}, "state": "ACTIVE",
+			"expiringScale": {"revertToInstances": 3, "startMillis": 1500000000000, "durationMillis": 3600000}}`, 20, 3},
+		// The scale has reverted, which is not a change.
+		{`{"request": {"id": "foo", "requestType": "WORKER", "instances": 3}, "state": "ACTIVE"}`, 20, 3},
+		// The request was scaled out of band.
+		{`{"request": {"id": "foo", "requestType": "WORKER", "instances": 7}, "state": "ACTIVE"}`, 7, 0},
+	}
+	for i, tt := range data {
+		body = tt.body
+		if err := resourceRequestRead(d, conn); err != nil {
+			t.Fatalf("Read %d: %v", i, err)
+		}
+		if v := d.Get("instances").(int); v != tt.instances {
+			t.Errorf("Read %d: expected instances %d, got %d", i, tt.instances, v)
+		}
+		if v := d.Get("expiring_scale.0.revert_to_instances").(int); v != tt.revertTo {
+			t.Errorf("Read %d: expected expiring_scale.0.revert_to_instances %d, got %d", i, tt.revertTo, v)
+		}
+	}
+}
+
+func TestBuildRequest(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceRequest().Schema, map[string]interface{}{
+		"request_id":                         "Foo",
+		"request_type":                       "SERVICE",
+		"instances":                          2,
+		"slave_placement":                    "GREEDY",
+		"required_slave_attributes":          map[string]interface{}{"instance_type": "m5.large"},
+		"allowed_slave_attributes":           map[string]interface{}{"az": "us-east-1a"},
+		"rack_affinity":                      []interface{}{"rack-1", "rack-2"},
+		"rack_sensitive":                     true,
+		"max_tasks_per_offer":                1,
+		"hide_even_number_across_racks_hint": true,
+		"allow_bounce_to_same_host":          true,
+		"owners":                             []interface{}{"ops@example.com"},
+		"required_role":                      "batch",
+		"group":                              "platform",
+		"read_only_groups":                   []interface{}{"support"},
+		"read_write_groups":                  []interface{}{"platform"},
+	})
+	req, err := buildRequest(d)
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"id":"foo","requestType":"SERVICE","instances":2,"slavePlacement":"GREEDY",` +
+		`"requiredSlaveAttributes":{"instance_type":"m5.large"},"allowedSlaveAttributes":{"az":"us-east-1a"},` +
+		`"rackAffinity":["rack-1","rack-2"],"rackSensitive":true,"maxTasksPerOffer":1,` +
+		`"hideEvenNumberAcrossRacksHint":true,"allowBounceToSameHost":true,` +
+		`"owners":["ops@example.com"],"requiredRole":"batch","group":"platform",` +
+		`"readOnlyGroups":["support"],"readWriteGroups":["platform"]}`
+	if string(b) != expect {
+		t.Errorf("buildRequest: expected %s, got %s", expect, b)
+	}
+}
+
+func TestResourceRequestReadOwnership(t *testing.T) {
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"request": {"id": "foo", "requestType": "SERVICE", "owners": ["ops@example.com"],
+			"requiredRole": "batch", "group": "platform", "readOnlyGroups": ["support", "audit"]}, "state": "ACTIVE"}`))
+	})
+	defer ts.Close()
+	d := resourceRequest().TestResourceData()
+	d.SetId("foo")
+	if err := resourceRequestRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !d.Get("owners").(*schema.Set).Contains("ops@example.com") {
+		t.Errorf("Read: expected owners to contain ops@example.com, got %v", d.Get("owners"))
+	}
+	if d.Get("read_only_groups").(*schema.Set).Len() != 2 {
+		t.Errorf("Read: expected 2 read_only_groups, got %v", d.Get("read_only_groups"))
+	}
+	if d.Get("required_role") != "batch" || d.Get("group") != "platform" {
+		t.Errorf("Read: unexpected required_role %v or group %v", d.Get("required_role"), d.Get("group"))
+	}
+}
+
+func TestBuildRequestSchedule(t *testing.T) {
+	var data = []struct {
+		raw       map[string]interface{}
+		expect    string
+		expectErr bool
+	}{
+		{
+			map[string]interface{}{
+				"schedule":                          "0 15 10 ? * MON-FRI",
+				"schedule_type":                     "QUARTZ",
+				"schedule_time_zone":                "Australia/Sydney",
+				"scheduled_expected_runtime_millis": 60000,
+				"task_execution_time_limit_millis":  300000,
+			},
+			`{"id":"foo","requestType":"SCHEDULED","quartzSchedule":"0 15 10 ? * MON-FRI","scheduleType":"QUARTZ",` +
+				`"scheduleTimeZone":"Australia/Sydney","scheduledExpectedRuntimeMillis":60000,` +
+				`"taskExecutionTimeLimitMillis":300000,"slavePlacement":"SEPARATE_BY_DEPLOY"}`,
+			false,
+		},
+		{
+			map[string]interface{}{
+				"schedule":      "0 7 * * *",
+				"schedule_type": "CRON",
+			},
+			`{"id":"foo","requestType":"SCHEDULED","schedule":"0 7 * * *","scheduleType":"CRON","slavePlacement":"SEPARATE_BY_DEPLOY"}`,
+			false,
+		},
+		{
+			map[string]interface{}{
+				"schedule":      "0 7 * * *",
+				"schedule_type": "QUARTZ",
+			},
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range data {
+		tt.raw["request_id"] = "foo"
+		tt.raw["request_type"] = "SCHEDULED"
+		d := schema.TestResourceDataRaw(t, resourceRequest().Schema, tt.raw)
+		req, err := buildRequest(d)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("buildRequest(%v): expected error %v, got %v", tt.raw, tt.expectErr, err)
+			continue
+		}
+		if tt.expectErr {
+			continue
+		}
+		b, _ := json.Marshal(req)
+		if string(b) != tt.expect {
+			t.Errorf("buildRequest(%v): expected %s, got %s", tt.raw, tt.expect, b)
+		}
+	}
+}
+
+// testRequestDiff plans a new singularity_request from raw.
+func testRequestDiff(t *testing.T, raw map[string]interface{}) (*terraform.InstanceDiff, error) {
+	c, err := config.NewRawConfig(raw)
+	if err != nil {
+		t.Fatalf("config error: %v", err)
+	}
+	return resourceRequest().Diff(nil, terraform.NewResourceConfig(c), nil)
+}
+
+func TestCustomizeRequestSchedule(t *testing.T) {
+	diff, err := testRequestDiff(t, map[string]interface{}{
+		"request_id":    "foo",
+		"request_type":  "SCHEDULED",
+		"schedule":      "0 7 * * *",
+		"schedule_type": "CRON",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := diff.Attributes["next_fire_times.#"].New; n != "5" {
+		t.Errorf("expected 5 next fire times, got %s", n)
+	}
+	if first := diff.Attributes["next_fire_times.0"].New; !strings.HasSuffix(first, "T07:00:00Z") {
+		t.Errorf("expected the first fire time at 07:00 UTC, got %s", first)
+	}
+
+	_, err = testRequestDiff(t, map[string]interface{}{
+		"request_id":    "foo",
+		"request_type":  "SCHEDULED",
+		"schedule":      "0 0 7 * * *",
+		"schedule_type": "QUARTZ",
+	})
+	if err == nil || !strings.Contains(err.Error(), "not a valid QUARTZ expression") {
+		t.Errorf("expected an invalid QUARTZ expression error, got %v", err)
+	}
+}
+
+func TestCustomizeRequestType(t *testing.T) {
+	var data = []struct {
+		raw       map[string]interface{}
+		expectErr string
+	}{
+		{
+			map[string]interface{}{"request_type": "SERVICE", "instances": 3},
+			"",
+		},
+		{
+			map[string]interface{}{"request_type": "ON_DEMAND", "instances": 2, "num_retries_on_failure": 1},
+			"",
+		},
+		{
+			map[string]interface{}{"request_type": "SERVICE", "schedule": "0 7 * * *", "schedule_type": "CRON"},
+			`"schedule" is only supported by SCHEDULED requests; "schedule_type" is only supported by SCHEDULED requests`,
+		},
+		{
+			map[string]interface{}{"request_type": "RUN_ONCE", "instances": 5},
+			`"instances" is only supported by SERVICE, WORKER, ON_DEMAND requests`,
+		},
+		{
+			map[string]interface{}{"request_type": "WORKER", "num_retries_on_failure": 3},
+			`"num_retries_on_failure" is only supported by SCHEDULED, RUN_ONCE, ON_DEMAND requests`,
+		},
+		{
+			map[string]interface{}{"request_type": "SCHEDULED", "instances": 2, "schedule": "0 7 * * *", "schedule_type": "CRON"},
+			`"instances" is only supported by SERVICE, WORKER, ON_DEMAND requests`,
+		},
+		{
+			map[string]interface{}{"request_type": "SCHEDULED", "instances": 1, "schedule": "0 7 * * *", "schedule_type": "CRON"},
+			"",
+		},
+		{
+			map[string]interface{}{"request_type": "SCHEDULED", "schedule_type": "CRON"},
+			`"schedule" is required for SCHEDULED requests`,
+		},
+		{
+			map[string]interface{}{"request_type": "WORKER", "scale": []interface{}{map[string]interface{}{"bounce": true}}},
+			`"scale" requires "instances"`,
+		},
+	}
+
+	for _, tt := range data {
+		tt.raw["request_id"] = "foo"
+		_, err := testRequestDiff(t, tt.raw)
+		if tt.expectErr == "" {
+			if err != nil {
+				t.Errorf("Diff(%v): unexpected error %v", tt.raw, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+			t.Errorf("Diff(%v): expected error containing %q, got %v", tt.raw, tt.expectErr, err)
+		}
+	}
+}
+
+func TestRequestFailureSettingsRoundTrip(t *testing.T) {
+	raw := map[string]interface{}{
+		"request_id":                                              "foo",
+		"request_type":                                            "ON_DEMAND",
+		"task_log_error_regex":                                    "(?i)exception|fatal",
+		"task_log_error_regex_case_sensitive":                     false,
+		"kill_old_non_long_running_tasks_after_millis":            600000,
+		"wait_at_least_millis_after_task_finishes_for_reschedule": 30000,
+	}
+	req, err := buildRequest(schema.TestResourceDataRaw(t, resourceRequest().Schema, raw))
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"id":"foo","requestType":"ON_DEMAND","slavePlacement":"SEPARATE_BY_DEPLOY",` +
+		`"taskLogErrorRegex":"(?i)exception|fatal","taskLogErrorRegexCaseSensitive":false,` +
+		`"killOldNonLongRunningTasksAfterMillis":600000,"waitAtLeastMillisAfterTaskFinishesForReschedule":30000}`
+	if string(b) != expect {
+		t.Fatalf("buildRequest: expected %s, got %s", expect, b)
+	}
+
+	conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"request": %s, "state": "ACTIVE"}`, b)
+	})
+	defer ts.Close()
+	d := resourceRequest().TestResourceData()
+	d.SetId("foo")
+	if err := resourceRequestRead(d, conn); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	for k, v := range raw {
+		if got := d.Get(k); got != v {
+			t.Errorf("Read: expected %s %v, got %v", k, v, got)
+		}
+	}
+}
+
+func TestBuildRequestTaskPriorityLevel(t *testing.T) {
+	var data = []struct {
+		raw    map[string]interface{}
+		expect string
+	}{
+		{map[string]interface{}{}, ""},
+		{map[string]interface{}{"task_priority_level": 0.0}, `"taskPriorityLevel":0`},
+		{map[string]interface{}{"task_priority_level": 0.8}, `"taskPriorityLevel":0.8`},
+	}
+
+	for _, tt := range data {
+		tt.raw["request_id"] = "foo"
+		tt.raw["request_type"] = "WORKER"
+		req, err := buildRequest(schema.TestResourceDataRaw(t, resourceRequest().Schema, tt.raw))
+		if err != nil {
+			t.Fatalf("buildRequest: %v", err)
+		}
+		b, _ := json.Marshal(req)
+		if tt.expect == "" && strings.Contains(string(b), "taskPriorityLevel") {
+			t.Errorf("buildRequest(%v): expected no taskPriorityLevel, got %s", tt.raw, b)
+		}
+		if !strings.Contains(string(b), tt.expect) {
+			t.Errorf("buildRequest(%v): expected %s in %s", tt.raw, tt.expect, b)
+		}
+	}
+}
+
+func TestResourceRequestDelete(t *testing.T) {
+	defer testShortPolls()()
+	var data = []struct {
+		raw         map[string]interface{}
+		expectCalls []string
+		expectBody  string
+		expectErr   bool
+	}{
+		{
+			map[string]interface{}{"deletion_protection": true},
+			nil, "", true,
+		},
+		{
+			map[string]interface{}{
+				"delete_from_load_balancer": true,
+				"delete_message":            "{{.RequestID}} ({{.RequestType}}) removed by Terraform",
+			},
+			[]string{"DELETE /api/requests/request/foo"},
+			`{"deleteFromLoadBalancer":true,"message":"foo (SERVICE) removed by Terraform"`,
+			false,
+		},
+		{
+			map[string]interface{}{"destroy_mode": "pause", "delete_message": "{{.DestroyMode}}"},
+			[]string{"POST /api/requests/request/foo/pause"},
+			`{"killTasks":true,"actionId":"terraform-`,
+			false,
+		},
+		{
+			map[string]interface{}{"destroy_mode": "abandon"},
+			nil, "", false,
+		},
+		{
+			map[string]interface{}{"destroy_mode": "scale_to_zero_then_delete"},
+			[]string{
+				"PUT /api/requests/request/foo/scale",
+				"GET /api/history/request/foo/tasks/active",
+				"GET /api/history/request/foo/tasks/active",
+				"DELETE /api/requests/request/foo",
+			},
+			`{"deleteFromLoadBalancer":false,"message":"Terraform detected changes"`,
+			false,
+		},
+	}
+
+	for _, tt := range data {
+		var calls []string
+		var body string
+		tasksLeft := 1
+		conn, ts := testServerConn(t, func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+			w.Header().Set("Content-Type", "application/json")
+			if strings.HasSuffix(r.URL.Path, "/tasks/active") {
+				if tasksLeft > 0 {
+					tasksLeft--
+					w.Write([]byte(`[{"taskId": {"id": "foo-1", "requestId": "foo"}}]`))
+					return
+				}
+				w.Write([]byte(`[]`))
+				return
+			}
+			w.Write([]byte(`{}`))
+		})
+		tt.raw["request_id"] = "foo"
+		tt.raw["request_type"] = "SERVICE"
+		d := schema.TestResourceDataRaw(t, resourceRequest().Schema, tt.raw)
+		d.SetId("foo")
+		err := resourceRequestDelete(d, conn)
+		ts.Close()
+
+		if (err != nil) != tt.expectErr {
+			t.Errorf("Delete(%v): expected error %v, got %v", tt.raw, tt.expectErr, err)
+			continue
+		}
+		if tt.expectErr {
+			if d.Id() != "foo" {
+				t.Errorf("Delete(%v): expected the ID to be kept, got %q", tt.raw, d.Id())
+			}
+			continue
+		}
+		if d.Id() != "" {
+			t.Errorf("Delete(%v): expected the ID to be cleared, got %q", tt.raw, d.Id())
+		}
+		if strings.Join(calls, ", ") != strings.Join(tt.expectCalls, ", ") {
+			t.Errorf("Delete(%v): expected calls %v, got %v", tt.raw, tt.expectCalls, calls)
+		}
+		if !strings.HasPrefix(body, tt.expectBody) {
+			t.Errorf("Delete(%v): expected body starting %s, got %s", tt.raw, tt.expectBody, body)
+		}
+	}
+}
+
+func TestCustomizeRequestDeletionProtection(t *testing.T) {
+	state := &terraform.InstanceState{
+		ID: "foo",
+		Attributes: map[string]string{
+			"id":                  "foo",
+			"request_id":          "foo",
+			"request_type":        "SERVICE",
+			"instances":           "1",
+			"deletion_protection": "true",
+		},
+	}
+	var data = []struct {
+		raw       map[string]interface{}
+		expectErr bool
+	}{
+		{map[string]interface{}{"request_type": "SERVICE", "instances": 2, "deletion_protection": true}, false},
+		{map[string]interface{}{"request_type": "WORKER", "instances": 1, "deletion_protection": true}, true},
+		// Turning protection off in the same apply still replaces a
+		// protected request.
+		{map[string]interface{}{"request_type": "WORKER", "instances": 1}, true},
+	}
+	check := func(state *terraform.InstanceState, raw map[string]interface{}, expectErr bool) {
+		raw["request_id"] = "foo"
+		c, err := config.NewRawConfig(raw)
+		if err != nil {
+			t.Fatalf("config error: %v", err)
+		}
+		_, err = resourceRequest().Diff(state, terraform.NewResourceConfig(c), nil)
+		if (err != nil) != expectErr {
+			t.Errorf("Diff(%v): expected error %v, got %v", raw, expectErr, err)
+		}
+	}
+
+	for _, tt := range data {
+		check(state, tt.raw, tt.expectErr)
+	}
+
+	// A request kept on destroy cannot change type, even when destroy_mode
+	// changes in the same apply.
+	for _, mode := range []string{"delete", "scale_to_zero_then_delete", "pause", "abandon"} {
+		kept := mode == "pause" || mode == "abandon"
+		state := &terraform.InstanceState{
+			ID: "foo",
+			Attributes: map[string]string{
+				"id":           "foo",
+				"request_id":   "foo",
+				"request_type": "SERVICE",
+				"instances":    "1",
+				"destroy_mode": mode,
+			},
+		}
+		check(state, map[string]interface{}{"request_type": "WORKER", "instances": 1}, kept)
+		check(state, map[string]interface{}{"request_type": "WORKER", "instances": 1, "destroy_mode": "delete"}, kept)
+		check(state, map[string]interface{}{"request_type": "SERVICE", "instances": 2, "destroy_mode": mode}, false)
+	}
+}
@@ -0,0 +1,199 @@
+package mesos_singularity
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryClass describes why a Singularity call may be retried.
+type retryClass int
+
+const (
+	retryNone retryClass = iota
+	// retryConnection is a connection error. The request may or may not
+	// have reached Singularity.
+	retryConnection
+	// retryServerError is a 5xx response. The request may or may not have
+	// been applied.
+	retryServerError
+	// retryThrottled is a 429 response. The request was rejected.
+	retryThrottled
+	// retryDeployInProgress is a 409 response because another deploy is
+	// still pending. The request was rejected.
+	retryDeployInProgress
+)
+
+func (c retryClass) String() string {
+	switch c {
+	case retryConnection:
+		return "connection error"
+	case retryServerError:
+		return "server error"
+	case retryThrottled:
+		return "throttled"
+	case retryDeployInProgress:
+		return "deploy in progress"
+	}
+	return "not retryable"
+}
+
+// needsSafeRequest reports whether a retry could apply the request twice,
+// in which case only requests that are safe to repeat are retried.
+func (c retryClass) needsSafeRequest() bool {
+	return c == retryConnection || c == retryServerError
+}
+
+// classifyResponse decides whether a response or error is worth retrying.
+// The body of a 409 is read to tell a pending deploy apart from other
+// conflicts, and is restored for the caller.
+func classifyResponse(resp *http.Response, err error) retryClass {
+	if err != nil {
+		return retryConnection
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return retryThrottled
+	case resp.StatusCode == http.StatusConflict:
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err == nil && strings.Contains(strings.ToLower(string(body)), "in progress") {
+			return retryDeployInProgress
+		}
+	case resp.StatusCode >= 500:
+		return retryServerError
+	}
+	return retryNone
+}
+
+// safeToRepeat reports whether sending req twice has the same effect as
+// sending it once. Reads and deletes are. Other mutating calls are only
+// when they carry a Singularity actionId, which Singularity uses to
+// deduplicate repeated actions.
+func safeToRepeat(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodDelete:
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+	var action struct {
+		ActionID string `json:"actionId"`
+	}
+	if err := json.NewDecoder(body).Decode(&action); err != nil {
+		return false
+	}
+	return action.ActionID != ""
+}
+
+// newActionID returns a unique Singularity actionId. The same id must be
+// reused when an action is retried so Singularity can deduplicate it.
+func newActionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("terraform-%d", time.Now().UnixNano())
+	}
+	return "terraform-" + hex.EncodeToString(b)
+}
+
+// retryTransport retries Singularity calls with capped exponential backoff
+// and full jitter. Throttled calls and calls rejected because of a pending
+// deploy are always retried. Connection errors and 5xx responses are only
+// retried for requests that are safe to repeat. Waiting stops as soon as the
+// request's context is done.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	minWait time.Duration
+	maxWait time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	safe := safeToRepeat(req)
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			r = new(http.Request)
+			*r = *req
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(r)
+		class := classifyResponse(resp, err)
+		if class == retryNone ||
+			attempt >= t.retries ||
+			(class.needsSafeRequest() && !safe) ||
+			(req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt, resp)
+		log.Printf("[DEBUG] Retrying %s %s in %s after %s (retry %d of %d)",
+			req.Method, req.URL, wait, class, attempt+1, t.retries)
+		if resp != nil {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff returns how long to wait before the given retry. A Retry-After
+// header on the response is honoured, capped at maxWait.
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			wait := time.Duration(s) * time.Second
+			if wait > t.maxWait {
+				return t.maxWait
+			}
+			return wait
+		}
+	}
+	ceiling := t.ceiling(attempt)
+	if ceiling <= t.minWait {
+		return t.minWait
+	}
+	return t.minWait + time.Duration(mrand.Int63n(int64(ceiling-t.minWait)))
+}
+
+// ceiling returns the longest backoff for the given retry: minWait doubled
+// for every attempt, up to maxWait. Doubling stops once half of maxWait is
+// passed, so it cannot overflow.
+func (t *retryTransport) ceiling(attempt int) time.Duration {
+	wait := t.minWait
+	for i := 0; i < attempt && wait < t.maxWait; i++ {
+		if wait > t.maxWait/2 {
+			return t.maxWait
+		}
+		wait *= 2
+	}
+	if wait > t.maxWait {
+		return t.maxWait
+	}
+	return wait
+}
@@ -0,0 +1,190 @@
+package mesos_singularity
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyResponse(t *testing.T) {
+	var data = []struct {
+		status int
+		body   string
+		expect retryClass
+	}{
+		{200, `{}`, retryNone},
+		{404, `{}`, retryNone},
+		{400, `invalid`, retryNone},
+		{409, `Pending deploy already in progress for foo`, retryDeployInProgress},
+		{409, `Request foo already exists`, retryNone},
+		{429, ``, retryThrottled},
+		{500, ``, retryServerError},
+		{503, ``, retryServerError},
+	}
+
+	for _, tt := range data {
+		resp := &http.Response{
+			StatusCode: tt.status,
+			Body:       ioutil.NopCloser(strings.NewReader(tt.body)),
+		}
+		actual := classifyResponse(resp, nil)
+		if actual != tt.expect {
+			t.Errorf("classifyResponse(%d, %s): expected %s, got %s", tt.status, tt.body, tt.expect, actual)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != tt.body {
+			t.Errorf("classifyResponse(%d): body not restored, got %s", tt.status, body)
+		}
+	}
+	if actual := classifyResponse(nil, http.ErrHandlerTimeout); actual != retryConnection {
+		t.Errorf("classifyResponse(error): expected %s, got %s", retryConnection, actual)
+	}
+}
+
+func TestSafeToRepeat(t *testing.T) {
+	var data = []struct {
+		method string
+		body   string
+		expect bool
+	}{
+		{"GET", ``, true},
+		{"DELETE", ``, true},
+		{"POST", `{"id":"foo"}`, false},
+		{"POST", `{"id":"foo","actionId":""}`, false},
+		{"PUT", `{"instances":2,"actionId":"terraform-1"}`, true},
+		{"POST", `not json`, false},
+	}
+
+	for _, tt := range data {
+		var body *bytes.Reader
+		req, _ := http.NewRequest(tt.method, "http://localhost/api", nil)
+		if tt.body != "" {
+			body = bytes.NewReader([]byte(tt.body))
+			req, _ = http.NewRequest(tt.method, "http://localhost/api", body)
+		}
+		actual := safeToRepeat(req)
+		if actual != tt.expect {
+			t.Errorf("safeToRepeat(%s %s): expected %v, got %v", tt.method, tt.body, tt.expect, actual)
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	var data = []struct {
+		method   string
+		body     string
+		statuses []int
+		expect   int
+		hits     int
+	}{
+		// Rejected calls are always retried.
+		{"POST", `{"id":"foo"}`, []int{429, 200}, 200, 2},
+		{"POST", `{"id":"foo"}`, []int{409, 200}, 200, 2},
+		// Server errors are retried for safe calls only.
+		{"GET", ``, []int{503, 503, 200}, 200, 3},
+		{"PUT", `{"actionId":"terraform-1"}`, []int{500, 200}, 200, 2},
+		{"POST", `{"id":"foo"}`, []int{500, 200}, 500, 1},
+		// Retries stop after the configured count.
+		{"GET", ``, []int{503, 503, 503, 503, 200}, 503, 4},
+		{"GET", ``, []int{404}, 404, 1},
+	}
+
+	for _, tt := range data {
+		var hits int
+		var bodies []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(b))
+			status := tt.statuses[hits]
+			hits++
+			w.WriteHeader(status)
+			if status == 409 {
+				w.Write([]byte("Pending deploy already in progress"))
+			}
+		}))
+
+		client := &http.Client{Transport: &retryTransport{
+			next:    http.DefaultTransport,
+			retries: 3,
+			minWait: time.Millisecond,
+			maxWait: 5 * time.Millisecond,
+		}}
+		req, _ := http.NewRequest(tt.method, ts.URL, nil)
+		if tt.body != "" {
+			req, _ = http.NewRequest(tt.method, ts.URL, bytes.NewReader([]byte(tt.body)))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %v: %v", tt.method, tt.statuses, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.expect {
+			t.Errorf("%s %v: expected status %d, got %d", tt.method, tt.statuses, tt.expect, resp.StatusCode)
+		}
+		if hits != tt.hits {
+			t.Errorf("%s %v: expected %d calls, got %d", tt.method, tt.statuses, tt.hits, hits)
+		}
+		for _, b := range bodies {
+			if b != tt.body {
+				t.Errorf("%s %v: expected body %q on every call, got %q", tt.method, tt.statuses, tt.body, b)
+			}
+		}
+		ts.Close()
+	}
+}
+
+func TestRetryTransportBackoff(t *testing.T) {
+	tr := &retryTransport{minWait: time.Second, maxWait: 10 * time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		wait := tr.backoff(attempt, nil)
+		if wait < tr.minWait || wait > tr.maxWait {
+			t.Errorf("backoff(%d): %s outside [%s, %s]", attempt, wait, tr.minWait, tr.maxWait)
+		}
+	}
+	// A long minWait doubled for many attempts would overflow.
+	long := &retryTransport{minWait: 10 * time.Second, maxWait: time.Hour}
+	for _, attempt := range []int{9, 30, 31, 64} {
+		if ceiling := long.ceiling(attempt); ceiling != time.Hour {
+			t.Errorf("ceiling(%d): expected %s, got %s", attempt, time.Hour, ceiling)
+		}
+	}
+	if wait := long.backoff(30, nil); wait <= long.minWait || wait > long.maxWait {
+		t.Errorf("backoff(30): %s outside (%s, %s]", wait, long.minWait, long.maxWait)
+	}
+	if ceiling := long.ceiling(2); ceiling != 40*time.Second {
+		t.Errorf("ceiling(2): expected 40s, got %s", ceiling)
+	}
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	if wait := tr.backoff(0, resp); wait != 3*time.Second {
+		t.Errorf("backoff with Retry-After: expected 3s, got %s", wait)
+	}
+}
+
+func TestRetryTransportCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &retryTransport{
+		next:    http.DefaultTransport,
+		retries: 3,
+		minWait: time.Minute,
+		maxWait: time.Minute,
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	start := time.Now()
+	if _, err := client.Do(req.WithContext(ctx)); err == nil {
+		t.Errorf("expected the cancelled call to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the backoff to stop when the context is done, took %s", elapsed)
+	}
+}
@@ -0,0 +1,439 @@
+package mesos_singularity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nextFireTimes is how many upcoming runs of a schedule are shown in the plan.
+const nextFireTimes = 5
+
+// scheduleSearchYears bounds the search for fire times, so expressions that
+// never fire, e.g. on February 30th, give up instead of looping.
+const scheduleSearchYears = 5
+
+// scheduleField describes one field of a cron or Quartz expression.
+type scheduleField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	monthNames = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+	cronDayNames = map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}
+	quartzDayNames = map[string]int{
+		"SUN": 1, "MON": 2, "TUE": 3, "WED": 4, "THU": 5, "FRI": 6, "SAT": 7,
+	}
+
+	secondField     = scheduleField{"second", 0, 59, nil}
+	minuteField     = scheduleField{"minute", 0, 59, nil}
+	hourField       = scheduleField{"hour", 0, 23, nil}
+	dayOfMonthField = scheduleField{"day of month", 1, 31, nil}
+	monthField      = scheduleField{"month", 1, 12, monthNames}
+	// Cron counts days of the week from Sunday as 0, and also accepts 7 for
+	// Sunday. Quartz counts from Sunday as 1.
+	cronDayOfWeekField   = scheduleField{"day of week", 0, 7, cronDayNames}
+	quartzDayOfWeekField = scheduleField{"day of week", 1, 7, quartzDayNames}
+	yearField            = scheduleField{"year", 1970, 2099, nil}
+)
+
+// schedule is a parsed cron or Quartz expression. Fields are bit sets of the
+// values they match, with days of the week normalised to Sunday as 0.
+type schedule struct {
+	second, minute, hour, dayOfMonth, month, dayOfWeek uint64
+	years                                              map[int]bool
+
+	// anyDayOfMonth and anyDayOfWeek are set for "*" and "?".
+	anyDayOfMonth, anyDayOfWeek bool
+
+	// Quartz only: "L" or "L-3" in day of month, "15W" and "LW".
+	lastDayOfMonth       bool
+	lastDayOfMonthOffset int
+	nearestWeekday       int
+	lastWeekday          bool
+
+	// Quartz only: "5L" (last Friday) and "6#3" (third Friday) in day of
+	// week. Both hold -1 when unused.
+	lastDayOfWeek int
+	nthDayOfWeek  int
+	nth           int
+}
+
+// parseSchedule parses expr as a schedule of scheduleType, CRON or QUARTZ.
+//
+// CRON takes 5 fields, or 6 with seconds first. QUARTZ takes seconds,
+// minutes, hours, day of month, month, day of week and an optional year, and
+// needs "?" in one of the day fields.
+func parseSchedule(expr, scheduleType string) (*schedule, error) {
+	fields := strings.Fields(expr)
+	quartz := strings.ToUpper(scheduleType) == "QUARTZ"
+	if quartz {
+		if len(fields) != 6 && len(fields) != 7 {
+			return nil, fmt.Errorf("quartz expression %q must have 6 or 7 fields, found %d", expr, len(fields))
+		}
+	} else {
+		if len(fields) != 5 && len(fields) != 6 {
+			return nil, fmt.Errorf("cron expression %q must have 5 or 6 fields, found %d", expr, len(fields))
+		}
+		if len(fields) == 5 {
+			fields = append([]string{"0"}, fields...)
+		}
+	}
+
+	s := &schedule{lastDayOfWeek: -1, nthDayOfWeek: -1}
+	var err error
+	if s.second, err = parseScheduleField(fields[0], secondField); err != nil {
+		return nil, err
+	}
+	if s.minute, err = parseScheduleField(fields[1], minuteField); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseScheduleField(fields[2], hourField); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseScheduleField(fields[4], monthField); err != nil {
+		return nil, err
+	}
+	if err := s.parseDayOfMonth(fields[3], quartz); err != nil {
+		return nil, err
+	}
+	if err := s.parseDayOfWeek(fields[5], quartz); err != nil {
+		return nil, err
+	}
+	if quartz && fields[3] != "?" && fields[5] != "?" {
+		return nil, fmt.Errorf("quartz expression %q must use '?' in either day of month or day of week", expr)
+	}
+	if len(fields) == 7 {
+		if s.years, err = parseYears(fields[6]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *schedule) parseDayOfMonth(text string, quartz bool) error {
+	if text == "*" || text == "?" {
+		s.anyDayOfMonth = true
+		return nil
+	}
+	upper := strings.ToUpper(text)
+	special := strings.ContainsAny(upper, "LW")
+	if special && !quartz {
+		return fmt.Errorf("day of month field %q: L and W are only supported in Quartz expressions", text)
+	}
+	switch {
+	case upper == "L":
+		s.lastDayOfMonth = true
+	case strings.HasPrefix(upper, "L-"):
+		offset, err := strconv.Atoi(upper[2:])
+		if err != nil || offset < 0 || offset > 30 {
+			return fmt.Errorf("day of month field %q: offset from the last day must be in range 0-30", text)
+		}
+		s.lastDayOfMonth = true
+		s.lastDayOfMonthOffset = offset
+	case upper == "LW":
+		s.lastWeekday = true
+	case strings.HasSuffix(upper, "W"):
+		day, err := strconv.Atoi(upper[:len(upper)-1])
+		if err != nil || day < dayOfMonthField.min || day > dayOfMonthField.max {
+			return fmt.Errorf("day of month field %q: W must follow a single day in range %d-%d",
+				text, dayOfMonthField.min, dayOfMonthField.max)
+		}
+		s.nearestWeekday = day
+	case special:
+		return fmt.Errorf("day of month field %q: unsupported use of L or W", text)
+	default:
+		bits, err := parseScheduleField(text, dayOfMonthField)
+		if err != nil {
+			return err
+		}
+		s.dayOfMonth = bits
+	}
+	return nil
+}
+
+func (s *schedule) parseDayOfWeek(text string, quartz bool) error {
+	if text == "*" || text == "?" {
+		s.anyDayOfWeek = true
+		return nil
+	}
+	field := cronDayOfWeekField
+	if quartz {
+		field = quartzDayOfWeekField
+	}
+	upper := strings.ToUpper(text)
+	if strings.ContainsAny(upper, "L#") {
+		if !quartz {
+			return fmt.Errorf("day of week field %q: L and # are only supported in Quartz expressions", text)
+		}
+		if upper == "L" {
+			// A lone L in day of week means Saturday.
+			s.dayOfWeek = 1 << 6
+			return nil
+		}
+		if strings.HasSuffix(upper, "L") {
+			day, err := parseScheduleValue(upper[:len(upper)-1], field)
+			if err != nil {
+				return fmt.Errorf("day of week field %q: %v", text, err)
+			}
+			s.lastDayOfWeek = day - 1
+			return nil
+		}
+		parts := strings.Split(upper, "#")
+		if len(parts) != 2 {
+			return fmt.Errorf("day of week field %q: unsupported use of L or #", text)
+		}
+		day, err := parseScheduleValue(parts[0], field)
+		if err != nil {
+			return fmt.Errorf("day of week field %q: %v", text, err)
+		}
+		nth, err := strconv.Atoi(parts[1])
+		if err != nil || nth < 1 || nth > 5 {
+			return fmt.Errorf("day of week field %q: the number after # must be in range 1-5", text)
+		}
+		s.nthDayOfWeek = day - 1
+		s.nth = nth
+		return nil
+	}
+
+	bits, err := parseScheduleField(text, field)
+	if err != nil {
+		return err
+	}
+	if quartz {
+		// Shift Quartz's 1-7 onto 0-6.
+		bits >>= 1
+	} else if bits&(1<<7) != 0 {
+		// Cron accepts both 0 and 7 for Sunday.
+		bits = bits&^(1<<7) | 1
+	}
+	s.dayOfWeek = bits
+	return nil
+}
+
+// parseScheduleField parses a comma separated list of values, ranges and
+// steps into a bit set. field.max must be below 64.
+func parseScheduleField(text string, field scheduleField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(text, ",") {
+		from, to, step, err := parseScheduleItem(item, field)
+		if err != nil {
+			return 0, fmt.Errorf("%s field %q: %v", field.name, text, err)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseYears parses the Quartz year field. Years do not fit in a bit set.
+func parseYears(text string) (map[int]bool, error) {
+	if text == "*" {
+		return nil, nil
+	}
+	years := make(map[int]bool)
+	for _, item := range strings.Split(text, ",") {
+		from, to, step, err := parseScheduleItem(item, yearField)
+		if err != nil {
+			return nil, fmt.Errorf("%s field %q: %v", yearField.name, text, err)
+		}
+		for v := from; v <= to; v += step {
+			years[v] = true
+		}
+	}
+	return years, nil
+}
+
+// parseScheduleItem parses "*", "5", "1-5", "*/15", "5/15" or "1-30/5".
+func parseScheduleItem(item string, field scheduleField) (from, to, step int, err error) {
+	step = 1
+	if i := strings.Index(item, "/"); i >= 0 {
+		step, err = strconv.Atoi(item[i+1:])
+		if err != nil || step < 1 {
+			return 0, 0, 0, fmt.Errorf("step %q must be a positive number", item[i+1:])
+		}
+		item = item[:i]
+		to = field.max
+	}
+
+	switch {
+	case item == "*":
+		return field.min, field.max, step, nil
+	case strings.Contains(item, "-"):
+		parts := strings.SplitN(item, "-", 2)
+		if from, err = parseScheduleValue(parts[0], field); err != nil {
+			return 0, 0, 0, err
+		}
+		if to, err = parseScheduleValue(parts[1], field); err != nil {
+			return 0, 0, 0, err
+		}
+		if from > to {
+			return 0, 0, 0, fmt.Errorf("range %s starts after it ends", item)
+		}
+		return from, to, step, nil
+	}
+
+	if from, err = parseScheduleValue(item, field); err != nil {
+		return 0, 0, 0, err
+	}
+	if to == 0 {
+		// A single value, unless a step was given.
+		to = from
+	}
+	return from, to, step, nil
+}
+
+// parseScheduleValue parses a number or name, and checks it is in range.
+func parseScheduleValue(text string, field scheduleField) (int, error) {
+	if v, ok := field.names[strings.ToUpper(text)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", text)
+	}
+	if v < field.min || v > field.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, field.min, field.max)
+	}
+	return v, nil
+}
+
+// next returns up to n fire times after t, in t's location.
+func (s *schedule) next(t time.Time, n int) []time.Time {
+	var times []time.Time
+	loc := t.Location()
+	t = t.Truncate(time.Second).Add(time.Second)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	end := day.AddDate(scheduleSearchYears, 0, 0)
+	for ; len(times) < n && day.Before(end); day = day.AddDate(0, 0, 1) {
+		if !s.matchDay(day) {
+			continue
+		}
+		for hour := 0; hour < 24 && len(times) < n; hour++ {
+			if s.hour&(1<<uint(hour)) == 0 {
+				continue
+			}
+			for minute := 0; minute < 60 && len(times) < n; minute++ {
+				if s.minute&(1<<uint(minute)) == 0 {
+					continue
+				}
+				for second := 0; second < 60 && len(times) < n; second++ {
+					if s.second&(1<<uint(second)) == 0 {
+						continue
+					}
+					fire := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, second, 0, loc)
+					// Skip times that do not exist because of a daylight
+					// saving change, and times already past.
+					if fire.Hour() != hour || fire.Before(t) {
+						continue
+					}
+					times = append(times, fire)
+				}
+			}
+		}
+	}
+	return times
+}
+
+func (s *schedule) matchDay(day time.Time) bool {
+	if s.month&(1<<uint(day.Month())) == 0 {
+		return false
+	}
+	if s.years != nil && !s.years[day.Year()] {
+		return false
+	}
+
+	dom := s.matchDayOfMonth(day)
+	dow := s.matchDayOfWeek(day)
+	switch {
+	case s.anyDayOfMonth && s.anyDayOfWeek:
+		return true
+	case s.anyDayOfMonth:
+		return dow
+	case s.anyDayOfWeek:
+		return dom
+	}
+	// Cron runs when either restricted day field matches.
+	return dom || dow
+}
+
+func (s *schedule) matchDayOfMonth(day time.Time) bool {
+	last := daysIn(day)
+	switch {
+	case s.lastDayOfMonth:
+		return day.Day() == last-s.lastDayOfMonthOffset
+	case s.lastWeekday:
+		return day.Day() == nearestWeekday(day, last)
+	case s.nearestWeekday > 0:
+		if s.nearestWeekday > last {
+			return false
+		}
+		return day.Day() == nearestWeekday(day, s.nearestWeekday)
+	}
+	return s.dayOfMonth&(1<<uint(day.Day())) != 0
+}
+
+func (s *schedule) matchDayOfWeek(day time.Time) bool {
+	weekday := int(day.Weekday())
+	switch {
+	case s.lastDayOfWeek >= 0:
+		return weekday == s.lastDayOfWeek && day.Day()+7 > daysIn(day)
+	case s.nthDayOfWeek >= 0:
+		return weekday == s.nthDayOfWeek && (day.Day()-1)/7+1 == s.nth
+	}
+	return s.dayOfWeek&(1<<uint(weekday)) != 0
+}
+
+// daysIn returns the number of days in day's month.
+func daysIn(day time.Time) int {
+	return time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// nearestWeekday returns the weekday closest to dom in day's month, without
+// crossing into another month, as Quartz's W does.
+func nearestWeekday(day time.Time, dom int) int {
+	t := time.Date(day.Year(), day.Month(), dom, 0, 0, 0, 0, time.UTC)
+	switch t.Weekday() {
+	case time.Saturday:
+		if dom == 1 {
+			return dom + 2
+		}
+		return dom - 1
+	case time.Sunday:
+		if dom == daysIn(t) {
+			return dom - 2
+		}
+		return dom + 1
+	}
+	return dom
+}
+
+// scheduleNextFireTimes returns the next fire times of expr after now as
+// RFC 3339 strings. Without a time zone, UTC is assumed, as the default zone
+// of the Singularity scheduler is not known here.
+func scheduleNextFireTimes(expr, scheduleType, timeZone string, now time.Time) ([]string, error) {
+	s, err := parseSchedule(expr, scheduleType)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if timeZone != "" {
+		if loc, err = time.LoadLocation(timeZone); err != nil {
+			return nil, err
+		}
+	}
+	var times []string
+	for _, t := range s.next(now.In(loc), nextFireTimes) {
+		times = append(times, t.Format(time.RFC3339))
+	}
+	return times, nil
+}
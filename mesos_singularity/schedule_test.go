@@ -0,0 +1,117 @@
+package mesos_singularity
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	var data = []struct {
+		expr         string
+		scheduleType string
+		expectErr    string
+	}{
+		{"0 25 * * *", "CRON", `hour field "25": value 25 out of range 0-23`},
+		{"61 * * * *", "CRON", `minute field "61": value 61 out of range 0-59`},
+		{"0 0 32 * *", "CRON", `day of month field "32": value 32 out of range 1-31`},
+		{"0 0 * 13 *", "CRON", `month field "13": value 13 out of range 1-12`},
+		{"0 0 * * 8", "CRON", `day of week field "8": value 8 out of range 0-7`},
+		{"0 0 * * FOO", "CRON", `day of week field "FOO": "FOO" is not a number`},
+		{"*/0 * * * *", "CRON", `minute field "*/0": step "0" must be a positive number`},
+		{"0 10-5 * * *", "CRON", `hour field "10-5": range 10-5 starts after it ends`},
+		{"0 0 L * *", "CRON", "only supported in Quartz expressions"},
+		{"* * *", "CRON", "must have 5 or 6 fields, found 3"},
+		{"0 0 12 * * *", "QUARTZ", "must use '?' in either day of month or day of week"},
+		{"0 0 12 ? * 0", "QUARTZ", `day of week field "0": value 0 out of range 1-7`},
+		{"0 0 12 ? * 6#6", "QUARTZ", "the number after # must be in range 1-5"},
+		{"0 0 12 ? * * 1900", "QUARTZ", `year field "1900": value 1900 out of range 1970-2099`},
+		{"0 7 * * *", "QUARTZ", "must have 6 or 7 fields, found 5"},
+	}
+
+	for _, tt := range data {
+		_, err := parseSchedule(tt.expr, tt.scheduleType)
+		if err == nil {
+			t.Errorf("parseSchedule(%q, %s): expected error containing %q", tt.expr, tt.scheduleType, tt.expectErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expectErr) {
+			t.Errorf("parseSchedule(%q, %s): expected error containing %q, got %q",
+				tt.expr, tt.scheduleType, tt.expectErr, err)
+		}
+	}
+}
+
+func TestScheduleNextFireTimes(t *testing.T) {
+	// A Monday.
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data = []struct {
+		expr         string
+		scheduleType string
+		timeZone     string
+		expect       []string
+	}{
+		{"30 2 * * *", "CRON", "", []string{
+			"2024-01-01T02:30:00Z", "2024-01-02T02:30:00Z", "2024-01-03T02:30:00Z",
+			"2024-01-04T02:30:00Z", "2024-01-05T02:30:00Z",
+		}},
+		{"*/20 * * * * *", "CRON", "", []string{
+			"2024-01-01T00:00:20Z", "2024-01-01T00:00:40Z", "2024-01-01T00:01:00Z",
+			"2024-01-01T00:01:20Z", "2024-01-01T00:01:40Z",
+		}},
+		// Either day field matches when both are restricted.
+		{"0 0 13 * 5", "CRON", "", []string{
+			"2024-01-05T00:00:00Z", "2024-01-12T00:00:00Z", "2024-01-13T00:00:00Z",
+			"2024-01-19T00:00:00Z", "2024-01-26T00:00:00Z",
+		}},
+		{"0 15 10 ? * MON-FRI", "QUARTZ", "Australia/Sydney", []string{
+			"2024-01-02T10:15:00+11:00", "2024-01-03T10:15:00+11:00", "2024-01-04T10:15:00+11:00",
+			"2024-01-05T10:15:00+11:00", "2024-01-08T10:15:00+11:00",
+		}},
+		{"0 0 12 L * ?", "QUARTZ", "", []string{
+			"2024-01-31T12:00:00Z", "2024-02-29T12:00:00Z", "2024-03-31T12:00:00Z",
+			"2024-04-30T12:00:00Z", "2024-05-31T12:00:00Z",
+		}},
+		{"0 0 9 ? * 6#3", "QUARTZ", "", []string{
+			"2024-01-19T09:00:00Z", "2024-02-16T09:00:00Z", "2024-03-15T09:00:00Z",
+			"2024-04-19T09:00:00Z", "2024-05-17T09:00:00Z",
+		}},
+		{"0 0 9 ? * 6L", "QUARTZ", "", []string{
+			"2024-01-26T09:00:00Z", "2024-02-23T09:00:00Z", "2024-03-29T09:00:00Z",
+			"2024-04-26T09:00:00Z", "2024-05-31T09:00:00Z",
+		}},
+		// June 1st 2024 is a Saturday.
+		{"0 0 9 1W 6 ? 2024-2025", "QUARTZ", "", []string{
+			"2024-06-03T09:00:00Z", "2025-06-02T09:00:00Z",
+		}},
+		{"0 0 30 2 *", "CRON", "", nil},
+	}
+
+	for _, tt := range data {
+		times, err := scheduleNextFireTimes(tt.expr, tt.scheduleType, tt.timeZone, now)
+		if err != nil {
+			t.Errorf("scheduleNextFireTimes(%q): unexpected error %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(times, tt.expect) {
+			t.Errorf("scheduleNextFireTimes(%q): expected %v, got %v", tt.expr, tt.expect, times)
+		}
+	}
+}
+
+func TestScheduleNextFireTimesSkipsMissingTimes(t *testing.T) {
+	// Clocks in Sydney go from 02:00 to 03:00 on 6 October 2024.
+	now := time.Date(2024, 10, 4, 12, 0, 0, 0, time.UTC)
+	times, err := scheduleNextFireTimes("0 30 2 * * ?", "QUARTZ", "Australia/Sydney", now)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expect := []string{
+		"2024-10-05T02:30:00+10:00", "2024-10-07T02:30:00+11:00", "2024-10-08T02:30:00+11:00",
+		"2024-10-09T02:30:00+11:00", "2024-10-10T02:30:00+11:00",
+	}
+	if !reflect.DeepEqual(times, expect) {
+		t.Errorf("expected %v, got %v", expect, times)
+	}
+}
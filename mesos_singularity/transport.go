@@ -0,0 +1,336 @@
+package mesos_singularity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty"
+)
+
+// tlsConfigured reports whether any TLS setting was given, in which case
+// https is assumed whatever the port.
+func (c *Config) tlsConfigured() bool {
+	return c.CAFile != "" ||
+		c.CAPEM != "" ||
+		c.ClientCert != "" ||
+		c.ClientKey != "" ||
+		c.InsecureSkipVerify
+}
+
+// scheme returns the configured scheme. Without one, https is used on
+// port 443 or when TLS settings are present, and http otherwise.
+func (c *Config) scheme() string {
+	if c.Scheme != "" {
+		return strings.ToLower(c.Scheme)
+	}
+	if c.Port == 443 || c.tlsConfigured() {
+		return "https"
+	}
+	return "http"
+}
+
+// hostURL returns the base URL every API path is appended to. A full
+// endpoint URL takes precedence over host and port. For host and port, the
+// port is omitted when it is the default one for the scheme.
+func (c *Config) hostURL() (string, error) {
+	if c.Endpoint != "" {
+		return endpointBaseURL(c.Endpoint)
+	}
+	if c.Host == "" {
+		return "", fmt.Errorf("one of endpoint or host must be set")
+	}
+	scheme := c.scheme()
+	u := scheme + "://" + c.Host
+	switch {
+	case c.Port == 0:
+	case scheme == "http" && c.Port == 80:
+	case scheme == "https" && c.Port == 443:
+	default:
+		u += ":" + strconv.Itoa(c.Port)
+	}
+	return u, nil
+}
+
+// endpointURLs returns the base URL of every configured Singularity
+// endpoint, in the order they should be tried.
+func (c *Config) endpointURLs() ([]string, error) {
+	if len(c.Endpoints) == 0 {
+		u, err := c.hostURL()
+		if err != nil {
+			return nil, err
+		}
+		return []string{u}, nil
+	}
+	var urls []string
+	for _, e := range c.Endpoints {
+		u, err := endpointBaseURL(e)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// endpointBaseURL turns a full Singularity API URL such as
+// https://mesos.example.com/singularity/api into the base URL the client
+// appends its /api/... paths to, here https://mesos.example.com/singularity.
+func endpointBaseURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("parse endpoint %q error: %v", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("endpoint %q must use the http or https scheme", endpoint)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("endpoint %q has no host", endpoint)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("endpoint %q must not have a query or fragment", endpoint)
+	}
+	prefix := strings.TrimRight(u.Path, "/")
+	prefix = strings.TrimSuffix(prefix, "/api")
+	return u.Scheme + "://" + u.Host + prefix, nil
+}
+
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	var ca []byte
+	var err error
+	switch {
+	case c.CAPEM != "":
+		ca = []byte(c.CAPEM)
+	case c.CAFile != "":
+		ca, err = ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca_file %s error: %v", c.CAFile, err)
+		}
+	}
+	if ca != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid PEM certificates found in CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return nil, fmt.Errorf("client_cert and client_key must be set together")
+	}
+	if c.ClientCert != "" {
+		cert, err := readPEM(c.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("read client_cert error: %v", err)
+		}
+		key, err := readPEM(c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("read client_key error: %v", err)
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate error: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
+
+// readPEM accepts either PEM encoded content or a path to a PEM file.
+func readPEM(v string) ([]byte, error) {
+	if strings.Contains(v, "-----BEGIN") {
+		return []byte(v), nil
+	}
+	return ioutil.ReadFile(v)
+}
+
+// transport returns the http transport used for all Singularity calls. It
+// mirrors http.DefaultTransport with our TLS settings applied.
+func (c *Config) transport() (*http.Transport, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}, nil
+}
+
+// failoverTransport sends every request to the pinned Singularity endpoint
+// and moves on to the next one on connection errors or 5xx responses, for
+// instance when a scheduler instance is down or not the leader. Requests that
+// are not safe to repeat only move on when they were never sent. Requests are
+// built against the first endpoint and rewritten here. The first endpoint
+// that answers is pinned for the rest of the run.
+type failoverTransport struct {
+	next      http.RoundTripper
+	endpoints []*url.URL
+
+	mu     sync.Mutex
+	pinned int
+	chosen bool
+}
+
+func newFailoverTransport(next http.RoundTripper, endpoints []string) (*failoverTransport, error) {
+	t := &failoverTransport{next: next}
+	for _, e := range endpoints {
+		u, err := url.Parse(e)
+		if err != nil {
+			return nil, err
+		}
+		t.endpoints = append(t.endpoints, u)
+	}
+	return t, nil
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	first := t.endpoints[0]
+	if req.URL.Host != first.Host || !strings.HasPrefix(req.URL.Path, first.Path) {
+		// Redirects, e.g. to the leader, are sent as is.
+		return t.next.RoundTrip(req)
+	}
+	path := strings.TrimPrefix(req.URL.Path, first.Path)
+
+	t.mu.Lock()
+	start := t.pinned
+	t.mu.Unlock()
+	safe := safeToRepeat(req)
+
+	var resp *http.Response
+	var err error
+	for i := range t.endpoints {
+		idx := (start + i) % len(t.endpoints)
+		if i > 0 {
+			if req.Body != nil && req.GetBody == nil {
+				// The body cannot be replayed against another endpoint.
+				break
+			}
+			if resp != nil {
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+		}
+		r, rerr := rewriteRequest(req, t.endpoints[idx], path, i > 0)
+		if rerr != nil {
+			return nil, rerr
+		}
+		resp, err = t.next.RoundTrip(r)
+		if err == nil && resp.StatusCode < 500 {
+			t.pin(idx)
+			return resp, nil
+		}
+		if err != nil {
+			log.Printf("[WARN] Singularity endpoint %s failed: %v", t.endpoints[idx], err)
+		} else {
+			log.Printf("[WARN] Singularity endpoint %s failed: %s", t.endpoints[idx], resp.Status)
+		}
+		if !safe && (err == nil || !notSent(err)) {
+			// The endpoint may have acted on the request.
+			break
+		}
+	}
+	return resp, err
+}
+
+// notSent reports whether err happened before the request reached the
+// endpoint, e.g. the connection was refused.
+func notSent(err error) bool {
+	op, ok := err.(*net.OpError)
+	return ok && op.Op == "dial"
+}
+
+func (t *failoverTransport) pin(idx int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.chosen && t.pinned == idx {
+		return
+	}
+	t.pinned = idx
+	t.chosen = true
+	log.Printf("[INFO] Using Singularity endpoint %s", t.endpoints[idx])
+}
+
+// hosts returns the host of every endpoint.
+func (t *failoverTransport) hosts() []string {
+	var hosts []string
+	for _, e := range t.endpoints {
+		hosts = append(hosts, e.Host)
+	}
+	return hosts
+}
+
+// rewriteRequest returns a shallow copy of req aimed at endpoint. The body
+// is reset when the request is being replayed.
+func rewriteRequest(req *http.Request, endpoint *url.URL, path string, replay bool) (*http.Request, error) {
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Scheme = endpoint.Scheme
+	u.Host = endpoint.Host
+	u.Path = endpoint.Path + path
+	u.RawPath = ""
+	r.URL = &u
+	r.Host = ""
+	if replay && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// redirectPolicy follows redirects, such as a non-leader instance pointing
+// at the leader. Headers, including credentials, are only carried over to
+// hosts that are configured Singularity endpoints. net/http copies most
+// headers on a redirect by itself, so credentials and the configured headers
+// are removed again for any other host.
+func redirectPolicy(hosts []string, headers map[string]string) resty.RedirectPolicy {
+	known := make(map[string]bool)
+	for _, h := range hosts {
+		known[strings.ToLower(h)] = true
+	}
+	return resty.RedirectPolicyFunc(func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after %d redirects", len(via))
+		}
+		log.Printf("[DEBUG] Following Singularity redirect to %s", req.URL)
+		if !known[strings.ToLower(req.URL.Host)] {
+			req.Header.Del("Authorization")
+			for k := range headers {
+				req.Header.Del(k)
+			}
+			return nil
+		}
+		for k, v := range via[0].Header {
+			req.Header[k] = v
+		}
+		return nil
+	})
+}
@@ -0,0 +1,157 @@
+package mesos_singularity
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testJSONHandler(hits *int, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{}`))
+	}
+}
+
+func TestFailoverTransport(t *testing.T) {
+	var downHits, upHits int
+	down := httptest.NewServer(testJSONHandler(&downHits, http.StatusServiceUnavailable))
+	defer down.Close()
+	up := httptest.NewServer(testJSONHandler(&upHits, http.StatusOK))
+	defer up.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	config := Config{
+		Endpoints: []string{
+			closed.URL + "/api",
+			down.URL + "/api",
+			up.URL + "/api",
+		},
+	}
+	conn, err := config.Client()
+	if err != nil {
+		t.Fatalf("Client(): %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		r, err := conn.sclient.GetRequestByID("foo")
+		if err != nil {
+			t.Fatalf("GetRequestByID(): %v", err)
+		}
+		if r.RestyResponse.StatusCode() != http.StatusOK {
+			t.Errorf("expected status 200, got %d", r.RestyResponse.StatusCode())
+		}
+	}
+	// The healthy endpoint is pinned after the first call.
+	if downHits != 1 {
+		t.Errorf("expected 1 call to the failing endpoint, got %d", downHits)
+	}
+	if upHits != 3 {
+		t.Errorf("expected 3 calls to the healthy endpoint, got %d", upHits)
+	}
+}
+
+func TestFailoverTransportUnsafeRequest(t *testing.T) {
+	var downHits, upHits int
+	down := httptest.NewServer(testJSONHandler(&downHits, http.StatusServiceUnavailable))
+	defer down.Close()
+	up := httptest.NewServer(testJSONHandler(&upHits, http.StatusOK))
+	defer up.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	var data = []struct {
+		endpoints    []string
+		expectStatus int
+		expectUpHits int
+	}{
+		// A 5xx may mean the deploy was created, so it is not sent again.
+		{[]string{down.URL, up.URL}, http.StatusServiceUnavailable, 0},
+		// A refused connection never reached Singularity.
+		{[]string{closed.URL, up.URL}, http.StatusOK, 1},
+	}
+
+	for _, tt := range data {
+		downHits, upHits = 0, 0
+		config := Config{Endpoints: tt.endpoints}
+		conn, err := config.Client()
+		if err != nil {
+			t.Fatalf("Client(): %v", err)
+		}
+		resp, err := callAPI(context.Background(), conn.sclient, http.MethodPost, "/api/deploys", deployRequest{}, nil, "foo", "bar")
+		if resp == nil || resp.StatusCode() != tt.expectStatus {
+			t.Errorf("%v: expected status %d, got %v (%v)", tt.endpoints, tt.expectStatus, resp, err)
+		}
+		if upHits != tt.expectUpHits {
+			t.Errorf("%v: expected %d calls to the healthy endpoint, got %d", tt.endpoints, tt.expectUpHits, upHits)
+		}
+	}
+}
+
+func TestFailoverTransportRedirect(t *testing.T) {
+	var auth string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer leader.Close()
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, leader.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer follower.Close()
+
+	config := Config{
+		Endpoints: []string{follower.URL, leader.URL},
+		Token:     "s3cr3t",
+	}
+	conn, err := config.Client()
+	if err != nil {
+		t.Fatalf("Client(): %v", err)
+	}
+	r, err := conn.sclient.GetRequestByID("foo")
+	if err != nil {
+		t.Fatalf("GetRequestByID(): %v", err)
+	}
+	if r.RestyResponse.StatusCode() != http.StatusOK {
+		t.Errorf("expected status 200, got %d", r.RestyResponse.StatusCode())
+	}
+	if auth != "Bearer s3cr3t" {
+		t.Errorf("expected credentials to follow the redirect, got %q", auth)
+	}
+}
+
+func TestFailoverTransportRedirectElsewhere(t *testing.T) {
+	var auth, apiKey string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		apiKey = r.Header.Get("X-Api-Key")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer other.Close()
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer endpoint.Close()
+
+	config := Config{
+		Endpoint: endpoint.URL,
+		Token:    "s3cr3t",
+		Headers:  map[string]string{"X-Api-Key": "k3y"},
+	}
+	conn, err := config.Client()
+	if err != nil {
+		t.Fatalf("Client(): %v", err)
+	}
+	if _, err := conn.sclient.GetRequestByID("foo"); err != nil {
+		t.Fatalf("GetRequestByID(): %v", err)
+	}
+	if auth != "" || apiKey != "" {
+		t.Errorf("expected credentials not to follow a redirect to another host, got %q and %q", auth, apiKey)
+	}
+}
@@ -1,6 +1,14 @@
 package mesos_singularity
 
-import "fmt"
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+)
 
 func validateRequestType(v interface{}, k string) (ws []string, errors []error) {
 	validTypes := map[string]struct{}{
@@ -23,19 +31,62 @@ func validateRequestType(v interface{}, k string) (ws []string, errors []error)
 }
 
 func validateRequestScheduleType(v interface{}, k string) (ws []string, errors []error) {
-	// Only allow cron as valid type since this is widely known and we use this.
-	// However, Singularity allows cron and quartz.
 	// Since Singularity expects uppercase of these values and to make this resource
 	// simpler, therefore just use upppercase.
 	validTypes := map[string]struct{}{
-		"CRON": {},
+		"CRON":   {},
+		"QUARTZ": {},
 	}
 
 	value := v.(string)
 
 	if _, ok := validTypes[value]; !ok {
 		errors = append(errors, fmt.Errorf(
-			"%q must be only ['CRON']", k))
+			"%q must be one of ['CRON', 'QUARTZ']", k))
+	}
+	return
+}
+
+func validateTimeZone(v interface{}, k string) (ws []string, errors []error) {
+	value := v.(string)
+	// time.LoadLocation treats "" and "Local" as the provider's own zone,
+	// which is meaningless to Singularity.
+	if value == "" || value == "Local" {
+		errors = append(errors, fmt.Errorf("%q must be a tz database time zone, got %q", k, value))
+		return
+	}
+	if _, err := time.LoadLocation(value); err != nil {
+		errors = append(errors, fmt.Errorf("%q must be a tz database time zone, got %q: %v", k, value, err))
+	}
+	return
+}
+
+// validateSchedule checks a cron or Quartz expression. schedule_type is not
+// known here, so the type is taken from the expression: 5 fields is cron, 7
+// or 6 with a "?" is Quartz, and other 6 field expressions may be either.
+// The diff checks it again against schedule_type.
+func validateSchedule(v interface{}, k string) (ws []string, errors []error) {
+	value := v.(string)
+	if value == "" {
+		return
+	}
+	var err error
+	switch n := len(strings.Fields(value)); {
+	case n == 5:
+		_, err = parseSchedule(value, "CRON")
+	case n == 7, n == 6 && strings.Contains(value, "?"):
+		_, err = parseSchedule(value, "QUARTZ")
+	case n == 6:
+		if _, err = parseSchedule(value, "CRON"); err != nil {
+			if _, quartzErr := parseSchedule(value, "QUARTZ"); quartzErr == nil {
+				err = nil
+			}
+		}
+	default:
+		err = fmt.Errorf("expected 5 or 6 fields for cron, or 6 or 7 for Quartz, found %d", n)
+	}
+	if err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid schedule: %v", k, err))
 	}
 	return
 }
@@ -49,7 +100,7 @@ func validateRequestState(v interface{}, k string) (ws []string, errors []error)
 
 	if _, ok := validTypes[value]; !ok {
 		errors = append(errors, fmt.Errorf(
-			"%q must be only ['ACTIVE', 'PAUSED]", k))
+			"%q must be only ['ACTIVE', 'PAUSED']", k))
 	}
 	return
 }
@@ -119,16 +170,204 @@ func validateSingularityDockerVolumeMode(v interface{}, k string) (ws []string,
 
 func validateRequestSlavePlacement(v interface{}, k string) (ws []string, errors []error) {
 	validTypes := map[string]struct{}{
+		"SEPARATE":            {},
 		"SEPARATE_BY_DEPLOY":  {},
 		"SEPARATE_BY_REQUEST": {},
 		"SEPARATE_ALL_SLAVES": {},
+		"OPTIMISTIC":          {},
+		"GREEDY":              {},
+	}
+
+	value := v.(string)
+
+	if _, ok := validTypes[value]; !ok {
+		errors = append(errors, fmt.Errorf(
+			"%q must be one of ['SEPARATE', 'SEPARATE_BY_DEPLOY', 'SEPARATE_BY_REQUEST', 'SEPARATE_ALL_SLAVES', 'OPTIMISTIC', 'GREEDY']", k))
+	}
+	return
+}
+
+func validateEmail(v interface{}, k string) (ws []string, errors []error) {
+	value := v.(string)
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		errors = append(errors, fmt.Errorf("%q must be an email address, got %q", k, value))
+	}
+	return
+}
+
+// javaRegexErrors are the RE2 syntax errors Java's regular expressions
+// reject too. Anything else RE2 rejects may be Java-only syntax, such as
+// lookaheads, backreferences or possessive quantifiers.
+var javaRegexErrors = map[syntax.ErrorCode]bool{
+	syntax.ErrMissingBracket:        true,
+	syntax.ErrMissingParen:          true,
+	syntax.ErrUnexpectedParen:       true,
+	syntax.ErrTrailingBackslash:     true,
+	syntax.ErrMissingRepeatArgument: true,
+}
+
+// validateRegex checks a pattern Singularity will match with Java's regular
+// expressions. Syntax errors both engines agree on fail the plan; a pattern
+// only RE2 rejects is passed on with a warning, as Java may accept it.
+func validateRegex(v interface{}, k string) (ws []string, errors []error) {
+	_, err := syntax.Parse(v.(string), syntax.Perl)
+	if err == nil {
+		return
+	}
+	e, ok := err.(*syntax.Error)
+	// A reversed range such as [z-a] or x{2,1} is an error, an unknown
+	// class such as \p{javaLowerCase} or a count over RE2's limit of 1000
+	// is not.
+	if ok && (javaRegexErrors[e.Code] ||
+		e.Code == syntax.ErrInvalidCharRange && !strings.HasPrefix(e.Expr, `\`) ||
+		e.Code == syntax.ErrInvalidRepeatSize && reversedRepeat(e.Expr)) {
+		errors = append(errors, fmt.Errorf("%q is not a valid regular expression: %v", k, err))
+		return
+	}
+	ws = append(ws, fmt.Sprintf("%q could not be checked, make sure Java accepts it: %v", k, err))
+	return
+}
+
+// reversedRepeat reports whether the last {min,max} repeat in expr has a
+// max below its min.
+func reversedRepeat(expr string) bool {
+	start := strings.LastIndex(expr, "{")
+	end := strings.LastIndex(expr, "}")
+	if start < 0 || end < start {
+		return false
+	}
+	bounds := strings.SplitN(expr[start+1:end], ",", 2)
+	if len(bounds) != 2 || bounds[1] == "" {
+		return false
+	}
+	min, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return false
+	}
+	max, err := strconv.Atoi(bounds[1])
+	return err == nil && min > max
+}
+
+func validatePriorityLevel(v interface{}, k string) (ws []string, errors []error) {
+	if value := v.(float64); value < 0 || value > 1 {
+		errors = append(errors, fmt.Errorf("%q must be between 0.0 and 1.0, got %v", k, value))
+	}
+	return
+}
+
+func validateHealthcheckProtocol(v interface{}, k string) (ws []string, errors []error) {
+	validTypes := map[string]struct{}{
+		"HTTP":   {},
+		"HTTPS":  {},
+		"HTTP2":  {},
+		"HTTPS2": {},
 	}
 
 	value := v.(string)
 
 	if _, ok := validTypes[value]; !ok {
 		errors = append(errors, fmt.Errorf(
-			"%q must be one of ['SEPARATE_BY_DEPLOY', 'SEPARATE_BY_REQUEST', 'SEPARATE_ALL_SLAVES']", k))
+			"%q must be one of ['HTTP', 'HTTPS', 'HTTP2', 'HTTPS2']", k))
+	}
+	return
+}
+
+func validateDestroyMode(v interface{}, k string) (ws []string, errors []error) {
+	validModes := map[string]struct{}{
+		"delete":                    {},
+		"pause":                     {},
+		"scale_to_zero_then_delete": {},
+		"abandon":                   {},
+	}
+
+	value := v.(string)
+
+	if _, ok := validModes[value]; !ok {
+		errors = append(errors, fmt.Errorf(
+			"%q must be one of ['delete', 'pause', 'scale_to_zero_then_delete', 'abandon']", k))
+	}
+	return
+}
+
+// validateDeleteMessage renders the template with sample values, so unknown
+// fields are caught as well as syntax errors.
+func validateDeleteMessage(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := renderDeleteMessage(v.(string), deleteMessageData{
+		RequestID:   "example",
+		RequestType: "SERVICE",
+		DestroyMode: "delete",
+	}); err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid template: %v", k, err))
+	}
+	return
+}
+
+func validatePositiveInt(v interface{}, k string) (ws []string, errors []error) {
+	if v.(int) < 0 {
+		errors = append(errors, fmt.Errorf("%q must not be negative, got %d", k, v.(int)))
+	}
+	return
+}
+
+func validateScheme(v interface{}, k string) (ws []string, errors []error) {
+	validTypes := map[string]struct{}{
+		"http":  {},
+		"https": {},
+	}
+
+	value := v.(string)
+
+	if _, ok := validTypes[value]; !ok {
+		errors = append(errors, fmt.Errorf(
+			"%q must be one of ['http', 'https']", k))
+	}
+	return
+}
+
+func validateEndpoint(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := endpointBaseURL(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%q: %v", k, err))
+	}
+	return
+}
+
+func validateDuration(v interface{}, k string) (ws []string, errors []error) {
+	value := v.(string)
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		errors = append(errors, fmt.Errorf("%q must be a duration such as '500ms' or '30s': %v", k, err))
+		return
+	}
+	if d < 0 {
+		errors = append(errors, fmt.Errorf("%q must not be negative", k))
+	}
+	return
+}
+
+// validatePositiveDuration is validateDuration that also rejects zero, for
+// durations that are multiplied, such as the base of a backoff.
+func validatePositiveDuration(v interface{}, k string) (ws []string, errors []error) {
+	ws, errors = validateDuration(v, k)
+	if d, err := time.ParseDuration(v.(string)); err == nil && d == 0 {
+		errors = append(errors, fmt.Errorf("%q must be greater than zero", k))
+	}
+	return
+}
+
+var deployIDPrefixPattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// validateDeployIDPrefix keeps generated deploy IDs within Singularity's
+// character set and length limit.
+func validateDeployIDPrefix(v interface{}, k string) (ws []string, errors []error) {
+	value := v.(string)
+	if !deployIDPrefixPattern.MatchString(value) {
+		errors = append(errors, fmt.Errorf("%q may only contain letters, digits and '_', got %q", k, value))
+	}
+	if len(value) > maxDeployIDPrefixLength {
+		errors = append(errors, fmt.Errorf("%q must be at most %d characters, got %d",
+			k, maxDeployIDPrefixLength, len(value)))
 	}
 	return
 }
@@ -1,7 +1,213 @@
 package mesos_singularity
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestValidateRequestType(t *testing.T) {
 
 }
+
+func TestValidateEndpoint(t *testing.T) {
+	var data = []struct {
+		value     string
+		expectErr bool
+	}{
+		{"https://mesos.example.com/singularity/api", false},
+		{"http://localhost:7099", false},
+		{"localhost:7099", true},
+		{"ftp://localhost/api", true},
+		{"https:///api", true},
+		{"https://localhost/api?foo=bar", true},
+	}
+
+	for _, tt := range data {
+		_, errs := validateEndpoint(tt.value, "endpoint")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validateEndpoint(%s): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	var data = []struct {
+		value     string
+		expectErr bool
+	}{
+		{"ops@example.com", false},
+		{"first.last+alerts@example.co.uk", false},
+		{"ops", true},
+		{"Ops <ops@example.com>", true},
+		{"", true},
+	}
+
+	for _, tt := range data {
+		_, errs := validateEmail(tt.value, "owners")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validateEmail(%s): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+	}
+}
+
+func TestValidateTimeZone(t *testing.T) {
+	var data = []struct {
+		value     string
+		expectErr bool
+	}{
+		{"Australia/Sydney", false},
+		{"UTC", false},
+		{"Australia/Gotham", true},
+		{"Local", true},
+		{"", true},
+	}
+
+	for _, tt := range data {
+		_, errs := validateTimeZone(tt.value, "schedule_time_zone")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validateTimeZone(%s): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	var data = []struct {
+		value     string
+		expectErr bool
+	}{
+		{"0 7 * * *", false},
+		{"*/15 * * * * *", false},
+		{"0 15 10 ? * MON-FRI", false},
+		{"0 15 10 ? * 6L 2030", false},
+		{"0 25 * * *", true},
+		{"0 0 12 ? * 0", true},
+		{"0 0 12 * * *", false},
+		{"* * *", true},
+	}
+
+	for _, tt := range data {
+		_, errs := validateSchedule(tt.value, "schedule")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validateSchedule(%s): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+	}
+}
+
+func TestValidateRegex(t *testing.T) {
+	var data = []struct {
+		value      string
+		expectErr  bool
+		expectWarn bool
+	}{
+		{"(?i)exception|fatal", false, false},
+		{"^ERROR [A-Z]+:", false, false},
+		{"error(", true, false},
+		{"error)", true, false},
+		{"[abc", true, false},
+		{"[z-a]", true, false},
+		{"x{2,1}", true, false},
+		{"(ab){10,2}", true, false},
+		// Java has no limit of 1000 on repeat counts.
+		{"a{1001}", false, true},
+		{"a{2,1001}", false, true},
+		{"(a{100}){100}", false, true},
+		// Java-only syntax RE2 can't check.
+		{"(?=fatal)", false, true},
+		{`(a)\1`, false, true},
+		{"a++", false, true},
+		{`\p{javaLowerCase}`, false, true},
+	}
+
+	for _, tt := range data {
+		ws, errs := validateRegex(tt.value, "task_log_error_regex")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validateRegex(%s): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+		if (len(ws) > 0) != tt.expectWarn {
+			t.Errorf("validateRegex(%s): expected warning %v, got %v", tt.value, tt.expectWarn, ws)
+		}
+	}
+}
+
+func TestValidatePositiveDuration(t *testing.T) {
+	var data = []struct {
+		value     string
+		expectErr bool
+	}{
+		{"500ms", false},
+		{"1s", false},
+		// A zero backoff base never grows.
+		{"0s", true},
+		{"0", true},
+		{"-1s", true},
+		{"soon", true},
+	}
+
+	for _, tt := range data {
+		_, errs := validatePositiveDuration(tt.value, "retry_min_wait")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validatePositiveDuration(%s): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+	}
+}
+
+func TestValidatePriorityLevel(t *testing.T) {
+	var data = []struct {
+		value     float64
+		expectErr bool
+	}{
+		{0, false},
+		{0.5, false},
+		{1, false},
+		{-0.1, true},
+		{1.5, true},
+	}
+
+	for _, tt := range data {
+		_, errs := validatePriorityLevel(tt.value, "task_priority_level")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validatePriorityLevel(%v): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+	}
+}
+
+func TestValidateDeployIDPrefix(t *testing.T) {
+	var data = []struct {
+		value     string
+		expectErr bool
+	}{
+		{"api", false},
+		{"api_v2_Blue", false},
+		{"api-v2", true},
+		{"api.v2", true},
+		{"", true},
+		{strings.Repeat("a", maxDeployIDPrefixLength), false},
+		{strings.Repeat("a", maxDeployIDPrefixLength+1), true},
+	}
+
+	for _, tt := range data {
+		_, errs := validateDeployIDPrefix(tt.value, "deploy_id_prefix")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validateDeployIDPrefix(%s): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+	}
+}
+
+func TestValidateDeleteMessage(t *testing.T) {
+	var data = []struct {
+		value     string
+		expectErr bool
+	}{
+		{"Terraform detected changes", false},
+		{"{{.RequestID}} ({{.RequestType}}) {{.DestroyMode}} by Terraform", false},
+		{"{{.RequestID", true},
+		{"{{.Owner}}", true},
+	}
+
+	for _, tt := range data {
+		_, errs := validateDeleteMessage(tt.value, "delete_message")
+		if (len(errs) > 0) != tt.expectErr {
+			t.Errorf("validateDeleteMessage(%s): expected error %v, got %v", tt.value, tt.expectErr, errs)
+		}
+	}
+}
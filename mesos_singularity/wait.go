@@ -0,0 +1,55 @@
+package mesos_singularity
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Intervals at which waits poll Singularity. Tests shorten them.
+var (
+	requestPollInterval = 2 * time.Second
+	deployPollInterval  = 5 * time.Second
+)
+
+// planTimeout bounds the calls made while planning, which has no configurable
+// timeout. Tests shorten it.
+var planTimeout = time.Minute
+
+// operationContext returns a context that is done once timeout, one of the
+// resource's configured timeouts, expires or Terraform is interrupted.
+func operationContext(m interface{}, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(stopContext(m), timeout)
+}
+
+// waitFor calls check every interval until it reports done or fails, and
+// gives up once ctx is done. what describes the wait in errors and should
+// name the request and deploy involved.
+func waitFor(ctx context.Context, interval time.Duration, what string, check func() (bool, error)) error {
+	for {
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if err := sleep(ctx, interval, what); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for d, or returns an error naming what was being waited for
+// when ctx is done first.
+func sleep(ctx context.Context, d time.Duration, what string) error {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timeout while waiting for %s", what)
+		}
+		return fmt.Errorf("interrupted while waiting for %s", what)
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+package mesos_singularity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testShortPolls makes waits poll every millisecond until the returned
+// function restores the intervals.
+func testShortPolls() func() {
+	request, deploy := requestPollInterval, deployPollInterval
+	requestPollInterval, deployPollInterval = time.Millisecond, time.Millisecond
+	return func() {
+		requestPollInterval, deployPollInterval = request, deploy
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	calls := 0
+	err := waitFor(context.Background(), time.Millisecond, "foo", func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	if err != nil {
+		t.Errorf("waitFor: unexpected error %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("waitFor: expected 3 checks, got %d", calls)
+	}
+
+	err = waitFor(context.Background(), time.Millisecond, "foo", func() (bool, error) {
+		return false, fmt.Errorf("boom")
+	})
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("waitFor: expected the check error, got %v", err)
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	what := "deploy abc of request foo to leave the pending state"
+	err := waitFor(ctx, time.Millisecond, what, func() (bool, error) {
+		return false, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "timeout") || !strings.Contains(err.Error(), what) {
+		t.Errorf("waitFor: expected a timeout error naming %q, got %v", what, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = waitFor(ctx, time.Millisecond, what, func() (bool, error) {
+		return false, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("waitFor: expected an interrupted error, got %v", err)
+	}
+}